@@ -0,0 +1,313 @@
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/reiver/go-telnet"
+)
+
+const (
+	// alertSubsBucket holds alert subscriptions keyed by player dbref.
+	alertSubsBucket = "alert_subscriptions"
+	// alertsSeenBucket holds, per player, the alerts already sent to them.
+	alertsSeenBucket = "alerts_seen"
+)
+
+// outboxSize is how many background commands wait for the connection.
+const outboxSize = 100
+
+// alertSeenFor is how long an alert without an expiry is remembered.
+const alertSeenFor = 48 * time.Hour
+
+// alertSubscription asks for severe weather alerts at the player's saved
+// location, delivered by page or @mail. The location is read at each poll,
+// so moving follows the player.
+type alertSubscription struct {
+	User  string    `json:"user"`
+	Via   string    `json:"via"`
+	Since time.Time `json:"since"`
+}
+
+// alertsSeen maps alert IDs to when they can be forgotten.
+type alertsSeen map[string]time.Time
+
+type WeatherAPIAlertsResponse struct {
+	Location struct {
+		Name    string `json:"name"`
+		Region  string `json:"region"`
+		Country string `json:"country"`
+	} `json:"location"`
+
+	Alerts struct {
+		Alert []weatherAlert `json:"alert"`
+	} `json:"alerts"`
+}
+
+type weatherAlert struct {
+	Headline    string `json:"headline"`
+	Severity    string `json:"severity"`
+	Areas       string `json:"areas"`
+	Event       string `json:"event"`
+	Effective   string `json:"effective"`
+	Expires     string `json:"expires"`
+	Desc        string `json:"desc"`
+	Instruction string `json:"instruction"`
+}
+
+// id identifies an alert across polls. Providers repeat the same alert
+// until it expires and issue updates with a new effective time.
+func (a weatherAlert) id() string {
+	sum := sha1.Sum([]byte(a.Event + "|" + a.Headline + "|" + a.Areas + "|" + a.Effective))
+	return hex.EncodeToString(sum[:8])
+}
+
+// expires is when the alert ends, or the zero time when it doesn't say.
+func (a weatherAlert) expires() time.Time {
+	t, _ := time.Parse(time.RFC3339, a.Expires)
+	return t
+}
+
+func (a weatherAlert) title() string {
+	if a.Headline != "" {
+		return a.Headline
+	}
+	return a.Event
+}
+
+func (app *application) alertSubs() repo[alertSubscription] {
+	return repo[alertSubscription]{st: app.store, bucket: alertSubsBucket}
+}
+
+func (app *application) alertsSeen() repo[alertsSeen] {
+	return repo[alertsSeen]{st: app.store, bucket: alertsSeenBucket}
+}
+
+// alertSource is the provider alerts come from. Only weatherapi has alert
+// data, so there is none unless it is in the weather chain.
+func (app *application) alertSource() *weatherAPIProvider {
+	for _, p := range app.weather {
+		if w, ok := p.(*weatherAPIProvider); ok {
+			return w
+		}
+	}
+	return nil
+}
+
+// alertsEnabled reports whether anything polls for alerts.
+func (app *application) alertsEnabled() bool {
+	return app.config.alertPoll > 0 && app.alertSource() != nil
+}
+
+// Alerts returns the active alerts covering loc.
+func (p *weatherAPIProvider) Alerts(loc string) (weatherPlace, []weatherAlert, error) {
+	var ar WeatherAPIAlertsResponse
+	if err := p.get("alerts.json", url.Values{"q": {loc}}, &ar); err != nil {
+		return weatherPlace{}, nil, err
+	}
+	place := weatherPlace{Name: ar.Location.Name, Region: ar.Location.Region, Country: ar.Location.Country}
+	return place, ar.Alerts.Alert, nil
+}
+
+// handleAlerts implements "gravybot alerts on [page|mail]|off|status".
+func (app *application) handleAlerts(userID, args string) string {
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		fields = []string{"status"}
+	}
+	r := app.alertSubs()
+	switch fields[0] {
+	case "status":
+		sub, ok, err := r.get(userID)
+		if err != nil {
+			app.errorLog.Printf("alert subscription %s: %s", userID, err)
+		}
+		if !ok {
+			return "@pemit " + userID + "=Gravybot: you aren't subscribed to weather alerts. Try: gravybot alerts on\n"
+		}
+		loc := app.prefs.get(userID).Location
+		if loc == "" {
+			loc = "no saved location"
+		}
+		return fmt.Sprintf("@pemit %s=Gravybot: weather alerts for %s by %s.\n", userID, mushEscape(loc), sub.Via)
+	case "on":
+		if !app.alertsEnabled() {
+			return "@pemit " + userID + "=Gravybot: weather alerts aren't available here.\n"
+		}
+		via := "page"
+		if len(fields) > 1 {
+			via = fields[1]
+		}
+		if via != "page" && via != "mail" {
+			break
+		}
+		loc := app.prefs.get(userID).Location
+		if loc == "" {
+			return "@pemit " + userID + "=Gravybot: alerts follow your saved location. Try: gravybot set location <place>\n"
+		}
+		if err := r.put(userID, alertSubscription{User: userID, Via: via, Since: time.Now().UTC()}); err != nil {
+			app.errorLog.Printf("alert subscription %s: %s", userID, err)
+			return "@pemit " + userID + "=Gravybot: couldn't save your subscription.\n"
+		}
+		return fmt.Sprintf("@pemit %s=Gravybot: you'll get weather alerts for %s by %s.\n", userID, mushEscape(loc), via)
+	case "off":
+		if err := r.delete(userID); err != nil {
+			app.errorLog.Printf("alert subscription %s: %s", userID, err)
+			return "@pemit " + userID + "=Gravybot: couldn't cancel your subscription.\n"
+		}
+		return "@pemit " + userID + "=Gravybot: weather alerts are off.\n"
+	}
+	return "@pemit " + userID + "=Gravybot: try gravybot alerts on \\[page|mail\\]|off|status\n"
+}
+
+// alertPoller checks subscribers' locations for new alerts every every and
+// hands the pages and @mail to send, which reports whether it took them.
+// Each location is asked about once per
+// poll however many players live there.
+type alertPoller struct {
+	app   *application
+	every time.Duration
+	send  func(command string) bool
+}
+
+// run polls every ap.every, forever.
+func (ap *alertPoller) run() {
+	for {
+		ap.poll(time.Now().UTC())
+		time.Sleep(ap.every)
+	}
+}
+
+// poll delivers alerts the subscribers haven't seen and returns how many
+// were sent.
+func (ap *alertPoller) poll(now time.Time) int {
+	app := ap.app
+	subs, err := app.alertSubs().all()
+	if err != nil {
+		app.errorLog.Printf("alerts: %s", err)
+		return 0
+	}
+	byLoc := map[string][]alertSubscription{}
+	queries := map[string]string{}
+	for _, sub := range subs {
+		loc := app.prefs.get(sub.User).Location
+		if loc == "" {
+			continue
+		}
+		query := parseLatLon(app.resolveLocation(loc))
+		key := strings.ToLower(query)
+		byLoc[key] = append(byLoc[key], sub)
+		queries[key] = query
+	}
+
+	source := app.alertSource()
+	if source == nil {
+		return 0
+	}
+	sent := 0
+	for key, subs := range byLoc {
+		place, alerts, err := source.Alerts(queries[key])
+		if err != nil {
+			app.errorLog.Printf("alerts for %s: %s", queries[key], err)
+			continue
+		}
+		for _, sub := range subs {
+			sent += ap.deliver(sub, place, alerts, now)
+		}
+	}
+	if sent > 0 {
+		app.infoLog.Printf("alerts: %d sent", sent)
+	}
+	return sent
+}
+
+// deliver sends sub the alerts it hasn't seen and forgets expired ones.
+func (ap *alertPoller) deliver(sub alertSubscription, place weatherPlace, alerts []weatherAlert, now time.Time) int {
+	r := ap.app.alertsSeen()
+	seen, _, err := r.get(sub.User)
+	if err != nil {
+		ap.app.errorLog.Printf("alerts seen %s: %s", sub.User, err)
+		return 0
+	}
+	if seen == nil {
+		seen = alertsSeen{}
+	}
+	changed := false
+	for id, until := range seen {
+		if now.After(until) {
+			delete(seen, id)
+			changed = true
+		}
+	}
+	sent := 0
+	for _, a := range alerts {
+		until := a.expires()
+		if !until.IsZero() && now.After(until) {
+			continue
+		}
+		if until.IsZero() {
+			until = now.Add(alertSeenFor)
+		}
+		id := a.id()
+		if _, ok := seen[id]; ok {
+			continue
+		}
+		// An alert that couldn't be queued is tried again next poll.
+		if !ap.send(alertCommand(sub, place, a)) {
+			continue
+		}
+		seen[id] = until
+		changed = true
+		sent++
+	}
+	if changed {
+		if err := r.put(sub.User, seen); err != nil {
+			ap.app.errorLog.Printf("alerts seen %s: %s", sub.User, err)
+		}
+	}
+	return sent
+}
+
+// alertCommand pages the headline, or @mails the whole alert.
+func alertCommand(sub alertSubscription, place weatherPlace, a weatherAlert) string {
+	if sub.Via == "mail" {
+		body := []string{mushEscape(a.title())}
+		for _, part := range []string{a.Areas, a.Desc, a.Instruction} {
+			if part = strings.TrimSpace(part); part != "" {
+				body = append(body, mushEscape(part))
+			}
+		}
+		subject := strings.ReplaceAll(mushEscape("Weather alert: "+a.Event), "/", " ")
+		return "@mail " + sub.User + "=" + subject + "/" + strings.Join(body, "%r%r") + "\n"
+	}
+	return "page " + sub.User + "=Gravybot: weather alert for " + mushEscape(place.String()+": "+a.title()) + "\n"
+}
+
+// queue hands a command to the connection's writer. It never blocks the
+// caller, so when the game has been unreachable long enough to fill the
+// outbox, new commands are dropped. It reports whether command was queued.
+func (app *application) queue(command string) bool {
+	select {
+	case app.outbox <- command:
+		return true
+	default:
+		app.errorLog.Printf("outbox full, dropped: %s", strings.TrimSpace(command))
+		return false
+	}
+}
+
+// drainOutbox sends queued commands to the game until done is closed.
+func (app *application) drainOutbox(w telnet.Writer, done <-chan struct{}) {
+	for {
+		select {
+		case command := <-app.outbox:
+			app.botSend(w, command)
+		case <-done:
+			return
+		}
+	}
+}
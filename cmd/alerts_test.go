@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// alertServer stands in for weatherapi's alerts endpoint, answering with
+// whatever alerts are current and counting lookups by place.
+type alertServer struct {
+	mu     sync.Mutex
+	alerts []weatherAlert
+	asked  map[string]int
+}
+
+func (as *alertServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+	q := r.URL.Query().Get("q")
+	as.asked[q]++
+	if r.URL.Path != "/alerts.json" || q != "Denver" {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	var resp WeatherAPIAlertsResponse
+	resp.Location.Name, resp.Location.Region, resp.Location.Country = "Denver", "Colorado", "United States of America"
+	resp.Alerts.Alert = as.alerts
+	json.NewEncoder(w).Encode(resp)
+}
+
+func newAlertApp(t *testing.T, as *alertServer) (*application, func()) {
+	t.Helper()
+	srv := httptest.NewServer(as)
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.config.weatherapikey = "test"
+	app.config.alertPoll = time.Minute
+	return app, srv.Close
+}
+
+func subscribe(t *testing.T, app *application, userID, loc, via string) {
+	t.Helper()
+	if _, err := app.prefs.set(userID, "location", loc); err != nil {
+		t.Fatal(err)
+	}
+	if got := app.handleAlerts(userID, "on "+via); !strings.Contains(got, "you'll get weather alerts") {
+		t.Fatalf("subscribe %s: %q", userID, got)
+	}
+}
+
+func TestHandleAlerts(t *testing.T) {
+	app := newTestApp()
+	if got := app.handleAlerts("#99", "on"); !strings.Contains(got, "aren't available") {
+		t.Errorf("disabled: %q", got)
+	}
+	app.config.weatherapikey, app.config.alertPoll = "test", time.Minute
+
+	if got := app.handleAlerts("#99", "on"); !strings.Contains(got, "set location") {
+		t.Errorf("no location: %q", got)
+	}
+	app.prefs.set("#99", "location", "Denver")
+	if got := app.handleAlerts("#99", "on fax"); !strings.Contains(got, "try gravybot alerts") {
+		t.Errorf("bad delivery: %q", got)
+	}
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot alerts on mail"`)
+	if got != "@pemit #99=Gravybot: you'll get weather alerts for Denver by mail.\n" {
+		t.Errorf("on: %q", got)
+	}
+	if got := app.handleAlerts("#99", ""); got != "@pemit #99=Gravybot: weather alerts for Denver by mail.\n" {
+		t.Errorf("status: %q", got)
+	}
+	app.handleAlerts("#99", "off")
+	if got := app.handleAlerts("#99", "status"); !strings.Contains(got, "aren't subscribed") {
+		t.Errorf("after off: %q", got)
+	}
+	// Only weatherapi has alerts; a chain without it has none to offer.
+	app.weather = weatherChain{&openMeteoProvider{app: app}}
+	if got := app.handleAlerts("#99", "on"); !strings.Contains(got, "aren't available") {
+		t.Errorf("without weatherapi: %q", got)
+	}
+}
+
+func TestAlertPoller_DeliversEachAlertOnce(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	storm := weatherAlert{
+		Headline: "Winter Storm Warning until Sunday evening", Event: "Winter Storm Warning",
+		Areas: "Denver; Boulder", Effective: "2026-10-18T03:00:00-06:00", Expires: "2026-10-19T18:00:00-06:00",
+		Desc: "Heavy snow, 8 to 14 inches.", Instruction: "Travel could be very difficult.",
+	}
+	old := weatherAlert{Headline: "Red Flag Warning", Event: "Red Flag Warning", Expires: "2026-10-17T18:00:00-06:00"}
+	as := &alertServer{alerts: []weatherAlert{storm, old}, asked: map[string]int{}}
+	app, done := newAlertApp(t, as)
+	defer done()
+
+	var sent []string
+	ap := &alertPoller{app: app, send: func(command string) bool { sent = append(sent, command); return true }}
+	app.locationAliases().put("dino", locationAlias{Name: "dino", Query: "Denver"})
+	subscribe(t, app, "#99", "Denver", "")
+	subscribe(t, app, "#42", "dino", "mail")
+	subscribe(t, app, "#7", "Nowhere", "")
+
+	if n := ap.poll(now); n != 2 {
+		t.Fatalf("first poll sent %d, want 2: %q", n, sent)
+	}
+	if as.asked["Denver"] != 1 || as.asked["Nowhere"] != 1 {
+		t.Errorf("lookups %v, want one per place", as.asked)
+	}
+	sort.Strings(sent)
+	want := []string{
+		"@mail #42=Weather alert: Winter Storm Warning/Winter Storm Warning until Sunday evening%r%rDenver; Boulder%r%r" +
+			"Heavy snow, 8 to 14 inches.%r%rTravel could be very difficult.\n",
+		"page #99=Gravybot: weather alert for Denver, Colorado: Winter Storm Warning until Sunday evening\n",
+	}
+	if strings.Join(sent, "") != strings.Join(want, "") {
+		t.Errorf("sent\n%q\nwant\n%q", sent, want)
+	}
+
+	sent = nil
+	if n := ap.poll(now.Add(15 * time.Minute)); n != 0 {
+		t.Errorf("repeat poll sent %q", sent)
+	}
+
+	as.mu.Lock()
+	as.alerts = append(as.alerts, weatherAlert{Headline: "Wind Advisory", Event: "Wind Advisory"})
+	as.mu.Unlock()
+	app.handleAlerts("#42", "off")
+	sent = nil
+	ap.poll(now.Add(30 * time.Minute))
+	if len(sent) != 1 || sent[0] != "page #99=Gravybot: weather alert for Denver, Colorado: Wind Advisory\n" {
+		t.Errorf("new alert: %q", sent)
+	}
+
+	// Once the storm has expired it is forgotten; the advisory, which has no
+	// expiry, is still remembered.
+	ap.poll(now.Add(48 * time.Hour))
+	seen, _, _ := app.alertsSeen().get("#99")
+	if _, ok := seen[storm.id()]; ok || len(seen) != 1 {
+		t.Errorf("seen after expiry: %v", seen)
+	}
+}
+
+func TestAlertPoller_RetriesAlertsTheOutboxDropped(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	as := &alertServer{alerts: []weatherAlert{{Headline: "Wind Advisory", Event: "Wind Advisory"}}, asked: map[string]int{}}
+	app, done := newAlertApp(t, as)
+	defer done()
+	subscribe(t, app, "#99", "Denver", "")
+
+	app.outbox = make(chan string, 1)
+	app.queue("page #1=filler\n")
+	ap := &alertPoller{app: app, send: app.queue}
+	if n := ap.poll(now); n != 0 {
+		t.Fatalf("full outbox took %d alerts", n)
+	}
+	if seen, _, _ := app.alertsSeen().get("#99"); len(seen) != 0 {
+		t.Errorf("dropped alert marked seen: %v", seen)
+	}
+
+	<-app.outbox
+	if n := ap.poll(now.Add(15 * time.Minute)); n != 1 {
+		t.Fatalf("retry sent %d, want 1", n)
+	}
+	if got := <-app.outbox; got != "page #99=Gravybot: weather alert for Denver, Colorado: Wind Advisory\n" {
+		t.Errorf("queued %q", got)
+	}
+}
+
+func TestOutbox_DrainsToWriter(t *testing.T) {
+	app := newTestApp()
+	app.outbox = make(chan string, 1)
+	if !app.queue("page #99=one\n") || app.queue("page #99=two\n") {
+		t.Error("queue should take one command and drop the next")
+	}
+
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		app.drainOutbox(&buf, done)
+		close(finished)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for len(app.outbox) > 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	close(done)
+	<-finished
+	if got := buf.String(); got != "page #99=one\n" {
+		t.Errorf("wrote %q", got)
+	}
+}
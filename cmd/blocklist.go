@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// blocklist screens posted URLs against hostname lists loaded from local
+// files. Each file is either one hostname per line or in hosts-file format
+// ("0.0.0.0 host [host...]"); "#" starts a comment in both. A host is blocked
+// when it, or any domain it is under, is listed. The lists can be reloaded
+// while the bot runs; a failed reload keeps the previous lists.
+type blocklist struct {
+	paths []string
+
+	mu    sync.RWMutex
+	hosts map[string]string // hostname -> name of the list it came from
+}
+
+// hostsFileNames are hosts-file entries that name the machine itself rather
+// than anything worth blocking.
+var hostsFileNames = map[string]bool{
+	"localhost": true, "localhost.localdomain": true, "local": true,
+	"broadcasthost": true, "ip6-localhost": true, "ip6-loopback": true,
+	"ip6-localnet": true, "ip6-mcastprefix": true, "ip6-allnodes": true,
+	"ip6-allrouters": true, "ip6-allhosts": true, "0.0.0.0": true,
+}
+
+func newBlocklist(paths []string) *blocklist {
+	b := &blocklist{hosts: map[string]string{}}
+	for _, p := range paths {
+		if p = strings.TrimSpace(p); p != "" {
+			b.paths = append(b.paths, p)
+		}
+	}
+	return b
+}
+
+// reload reads every list again and returns the number of hosts loaded.
+func (b *blocklist) reload() (int, error) {
+	hosts := map[string]string{}
+	for _, path := range b.paths {
+		if err := readBlocklist(path, hosts); err != nil {
+			return 0, err
+		}
+	}
+	b.mu.Lock()
+	b.hosts = hosts
+	b.mu.Unlock()
+	return len(hosts), nil
+}
+
+func readBlocklist(path string, hosts map[string]string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if net.ParseIP(fields[0]) != nil {
+			fields = fields[1:]
+		}
+		for _, h := range fields {
+			h = strings.TrimSuffix(strings.ToLower(h), ".")
+			if h != "" && !hostsFileNames[h] {
+				hosts[h] = name
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// match returns the list that blocks host, if any.
+func (b *blocklist) match(host string) (string, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for host != "" {
+		if list, ok := b.hosts[host]; ok {
+			return list, true
+		}
+		_, parent, found := strings.Cut(host, ".")
+		if !found {
+			break
+		}
+		host = parent
+	}
+	return "", false
+}
+
+// screenURL checks a posted URL against the blocklists. When it is blocked
+// the admins are told, and the returned text is what to say in its place:
+// a warning, or nothing when -blockaction is suppress.
+func (app *application) screenURL(authorID, authorName, host string) (string, bool) {
+	if app.blocklist == nil {
+		return "", false
+	}
+	list, ok := app.blocklist.match(host)
+	if !ok {
+		return "", false
+	}
+
+	app.infoLog.Printf("blocked URL host %s (list %s) posted by %s(%s)", host, list, authorName, authorID)
+	var out string
+	for _, a := range strings.Split(app.config.admins, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			out += "@pemit " + a + "=Gravybot: blocked link to " + mushEscape(host) + " (" + mushEscape(list) +
+				") posted by " + mushEscape(authorName) + "(" + authorID + ").\n"
+		}
+	}
+	if app.config.blockAction != "suppress" {
+		out += "pose > Careful: that link goes to " + mushEscape(host) + ", which is on the " + mushEscape(list) + " blocklist.\n"
+	}
+	return out, true
+}
+
+// handleBlocklistReload implements "gravybot urls reload" for admins.
+func (app *application) handleBlocklistReload(userID string) string {
+	if !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: only admins can reload the blocklists.\n"
+	}
+	if app.blocklist == nil {
+		return "@pemit " + userID + "=Gravybot: no blocklists are configured.\n"
+	}
+	n, err := app.blocklist.reload()
+	if err != nil {
+		app.errorLog.Printf("blocklist reload: %s", err)
+		return "@pemit " + userID + "=Gravybot: reload failed, keeping the old lists: " + mushEscape(err.Error()) + "\n"
+	}
+	return fmt.Sprintf("@pemit %s=Gravybot: blocklists reloaded, %d hosts.\n", userID, n)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeBlocklist writes a list file into dir and returns its path.
+func writeBlocklist(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBlocklist_Formats(t *testing.T) {
+	dir := t.TempDir()
+	plain := writeBlocklist(t, dir, "phishing.txt", `# phishing domains
+login-bank.example
+Evil.Example.   # trailing dot and case
+`)
+	hosts := writeBlocklist(t, dir, "malware.hosts", `127.0.0.1 localhost
+::1 ip6-localhost ip6-loopback
+0.0.0.0 0.0.0.0
+0.0.0.0 dropper.example payload.example
+127.0.0.1	tabs.example
+`)
+	b := newBlocklist([]string{plain, " ", hosts})
+	n, err := b.reload()
+	if err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if n != 5 {
+		t.Errorf("loaded %d hosts, want 5", n)
+	}
+
+	tests := []struct {
+		host, list string
+		blocked    bool
+	}{
+		{"login-bank.example", "phishing", true},
+		{"evil.example", "phishing", true},
+		{"cdn.EVIL.example", "phishing", true},
+		{"payload.example", "malware", true},
+		{"tabs.example", "malware", true},
+		{"notevil.example", "", false},
+		{"example", "", false},
+		{"localhost", "", false},
+	}
+	for _, tt := range tests {
+		list, blocked := b.match(tt.host)
+		if blocked != tt.blocked || list != tt.list {
+			t.Errorf("match(%s) = %q, %v; want %q, %v", tt.host, list, blocked, tt.list, tt.blocked)
+		}
+	}
+}
+
+func TestBlocklist_ReloadKeepsOldOnError(t *testing.T) {
+	dir := t.TempDir()
+	path := writeBlocklist(t, dir, "bad.txt", "evil.example\n")
+	b := newBlocklist([]string{path})
+	b.reload()
+
+	writeBlocklist(t, dir, "bad.txt", "worse.example\n")
+	if _, err := b.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if _, ok := b.match("evil.example"); ok {
+		t.Error("old entry survived reload")
+	}
+	if _, ok := b.match("worse.example"); !ok {
+		t.Error("new entry not loaded")
+	}
+
+	os.Remove(path)
+	if _, err := b.reload(); err == nil {
+		t.Error("reload of missing file should fail")
+	}
+	if _, ok := b.match("worse.example"); !ok {
+		t.Error("failed reload dropped the previous list")
+	}
+}
+
+func newBlockingApp(t *testing.T, action string) (*application, *stubShortener) {
+	t.Helper()
+	app := newTestApp()
+	app.config.admins = "#1"
+	app.config.blockAction = action
+	app.blocklist = newBlocklist([]string{writeBlocklist(t, t.TempDir(), "phishing.txt", "evil.example\n")})
+	app.blocklist.reload()
+	short := &stubShortener{name: "yirp", short: "https://y.rp/s1"}
+	app.shorteners = shortenerChain{short}
+	return app, short
+}
+
+func TestProcessUrls_BlockedFlagged(t *testing.T) {
+	app, short := newBlockingApp(t, "flag")
+
+	out, _ := app.processUrls("#42", "Mallory", "#100", []string{"https://login.evil.example/account"})
+	want := "@pemit #1=Gravybot: blocked link to login.evil.example (phishing) posted by Mallory(#42).\n" +
+		"pose > Careful: that link goes to login.evil.example, which is on the phishing blocklist.\n"
+	if out != want {
+		t.Errorf("got %q\nwant %q", out, want)
+	}
+	if short.calls != 0 {
+		t.Error("blocked URL was shortened")
+	}
+	if recs, _ := app.urls.find(nil); len(recs) != 0 {
+		t.Errorf("blocked URL recorded: %+v", recs)
+	}
+}
+
+func TestProcessUrls_BlockedSuppressed(t *testing.T) {
+	app, _ := newBlockingApp(t, "suppress")
+
+	out, _ := app.processUrls("#42", "Mallory", "#100", []string{"https://evil.example/", "https://example.com/ok"})
+	if strings.Contains(out, "pose > Careful") || !strings.HasPrefix(out, "@pemit #1=Gravybot: blocked link") {
+		t.Errorf("unexpected output %q", out)
+	}
+	if !strings.Contains(out, "add_url #42 https://y.rp/s1 https://example.com/ok\n") {
+		t.Errorf("clean URL not captured: %q", out)
+	}
+}
+
+func TestHandleURLs_Reload(t *testing.T) {
+	app, _ := newBlockingApp(t, "flag")
+	if got := app.handleURLs("#42", "reload", time.Now()); !strings.Contains(got, "only admins") {
+		t.Errorf("non-admin reply %q", got)
+	}
+	if got := app.handleURLs("#1", "reload", time.Now()); got != "@pemit #1=Gravybot: blocklists reloaded, 1 hosts.\n" {
+		t.Errorf("admin reply %q", got)
+	}
+	app.blocklist = nil
+	if got := app.handleURLs("#1", "reload", time.Now()); !strings.Contains(got, "no blocklists") {
+		t.Errorf("unconfigured reply %q", got)
+	}
+}
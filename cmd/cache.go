@@ -0,0 +1,299 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// cacheFileName is where the response cache is saved when persistence is on.
+const cacheFileName = "cache.json"
+
+// defaultCacheTTLs are the lifetimes of cached lookups by provider.
+const defaultCacheTTLs = "weather=10m,history=720h,quotes=30s,translations=24h"
+
+// responseCache remembers replies from external APIs so a room full of people
+// asking about the same city costs one round trip. Entries live for their
+// provider's TTL, the least recently used are evicted beyond max entries, and
+// concurrent identical lookups share a single fetch. A nil cache caches
+// nothing.
+type responseCache struct {
+	mu      sync.Mutex
+	ttls    map[string]time.Duration
+	max     int
+	lru     *list.List // of *cacheEntry, most recently used first
+	items   map[string]*list.Element
+	flights map[string]*cacheFlight
+	stats   map[string]*cacheStats
+	dirty   bool
+	now     func() time.Time
+}
+
+type cacheEntry struct {
+	Provider string          `json:"provider"`
+	Key      string          `json:"key"`
+	Value    json.RawMessage `json:"value"`
+	Expires  time.Time       `json:"expires"`
+}
+
+// cacheFlight is a fetch in progress that later callers wait on.
+type cacheFlight struct {
+	done  chan struct{}
+	value []byte
+	err   error
+}
+
+type cacheStats struct {
+	Hits      int64
+	Misses    int64
+	Coalesced int64
+	Evictions int64
+}
+
+func newResponseCache(ttls map[string]time.Duration, max int) *responseCache {
+	return &responseCache{
+		ttls:    ttls,
+		max:     max,
+		lru:     list.New(),
+		items:   map[string]*list.Element{},
+		flights: map[string]*cacheFlight{},
+		stats:   map[string]*cacheStats{},
+		now:     time.Now,
+	}
+}
+
+// parseCacheTTLs reads "provider=duration,..." as given to -cachettl.
+func parseCacheTTLs(s string) (map[string]time.Duration, error) {
+	ttls := map[string]time.Duration{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("cache TTL %q is not provider=duration", part)
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil {
+			return nil, fmt.Errorf("cache TTL for %s: %w", name, err)
+		}
+		ttls[strings.ToLower(strings.TrimSpace(name))] = d
+	}
+	return ttls, nil
+}
+
+// cached returns the cached value for key under provider, or calls fetch.
+// fetch also reports whether its value may be kept; failures never are.
+// Callers fold case into key where it doesn't matter.
+func cached[T any](c *responseCache, provider, key string, fetch func() (T, bool, error)) (T, error) {
+	var v T
+	if c == nil {
+		v, _, err := fetch()
+		return v, err
+	}
+	data, err := c.do(provider, key, func() ([]byte, bool, error) {
+		v, keep, err := fetch()
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(v)
+		return data, keep, err
+	})
+	if err != nil {
+		return v, err
+	}
+	err = json.Unmarshal(data, &v)
+	return v, err
+}
+
+// cachedReply caches a lookup that reports API trouble in its reply rather
+// than as an error. Replies starting with errPrefix are passed on uncached.
+func cachedReply(c *responseCache, provider, key, errPrefix string, fetch func() (string, error)) (string, error) {
+	return cached(c, provider, key, func() (string, bool, error) {
+		reply, err := fetch()
+		return reply, !strings.HasPrefix(reply, errPrefix), err
+	})
+}
+
+func (c *responseCache) do(provider, key string, fetch func() ([]byte, bool, error)) ([]byte, error) {
+	id := provider + "|" + key
+	c.mu.Lock()
+	st := c.statsFor(provider)
+	if el, ok := c.items[id]; ok {
+		e := el.Value.(*cacheEntry)
+		if c.now().Before(e.Expires) {
+			c.lru.MoveToFront(el)
+			st.Hits++
+			c.mu.Unlock()
+			return e.Value, nil
+		}
+		c.remove(el)
+	}
+	if f, ok := c.flights[id]; ok {
+		st.Coalesced++
+		c.mu.Unlock()
+		<-f.done
+		return f.value, f.err
+	}
+	st.Misses++
+	f := &cacheFlight{done: make(chan struct{})}
+	c.flights[id] = f
+	c.mu.Unlock()
+
+	// The flight is ended even if fetch panics, so waiters get an error
+	// rather than blocking forever and later lookups fetch afresh.
+	keep := false
+	f.err = fmt.Errorf("%s lookup failed", provider)
+	defer func() {
+		c.mu.Lock()
+		delete(c.flights, id)
+		if ttl := c.ttls[provider]; f.err == nil && keep && ttl > 0 {
+			c.add(&cacheEntry{Provider: provider, Key: key, Value: f.value, Expires: c.now().Add(ttl)})
+		}
+		c.mu.Unlock()
+		close(f.done)
+	}()
+
+	f.value, keep, f.err = fetch()
+	return f.value, f.err
+}
+
+// add stores e as the most recently used entry, evicting as needed. The
+// caller holds c.mu.
+func (c *responseCache) add(e *cacheEntry) {
+	id := e.Provider + "|" + e.Key
+	if el, ok := c.items[id]; ok {
+		c.remove(el)
+	}
+	c.items[id] = c.lru.PushFront(e)
+	c.dirty = true
+	for c.max > 0 && c.lru.Len() > c.max {
+		oldest := c.lru.Back()
+		c.statsFor(oldest.Value.(*cacheEntry).Provider).Evictions++
+		c.remove(oldest)
+	}
+}
+
+func (c *responseCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.items, e.Provider+"|"+e.Key)
+	c.dirty = true
+}
+
+func (c *responseCache) statsFor(provider string) *cacheStats {
+	st, ok := c.stats[provider]
+	if !ok {
+		st = &cacheStats{}
+		c.stats[provider] = st
+	}
+	return st
+}
+
+// clear drops every entry, keeping the stats.
+func (c *responseCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := c.lru.Len()
+	c.lru.Init()
+	c.items = map[string]*list.Element{}
+	c.dirty = true
+	return n
+}
+
+// summary describes the cache size and per-provider counters.
+func (c *responseCache) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	names := make([]string, 0, len(c.stats))
+	for name := range c.stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := []string{fmt.Sprintf("%d/%d entries", c.lru.Len(), c.max)}
+	for _, name := range names {
+		st := c.stats[name]
+		parts = append(parts, fmt.Sprintf("%s %d hits, %d misses, %d coalesced, %d evicted",
+			name, st.Hits, st.Misses, st.Coalesced, st.Evictions))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// save writes the unexpired entries to dir if anything changed since the
+// last save, oldest first so load restores the same order.
+func (c *responseCache) save(dir string) error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	now := c.now()
+	var entries []*cacheEntry
+	for el := c.lru.Back(); el != nil; el = el.Prev() {
+		if e := el.Value.(*cacheEntry); now.Before(e.Expires) {
+			entries = append(entries, e)
+		}
+	}
+	c.dirty = false
+	c.mu.Unlock()
+
+	data, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, cacheFileName)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// load restores entries saved by save, skipping any that have expired. A
+// missing file is not an error.
+func (c *responseCache) load(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, cacheFileName))
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	var entries []*cacheEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return 0, fmt.Errorf("parse %s: %w", cacheFileName, err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	for _, e := range entries {
+		if now.Before(e.Expires) {
+			c.add(e)
+		}
+	}
+	c.dirty = false
+	return c.lru.Len(), nil
+}
+
+// handleCache implements the admin-only "gravybot cache [stats|clear]".
+func (app *application) handleCache(userID, args string) string {
+	if !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: only admins can inspect the cache.\n"
+	}
+	if app.cache == nil {
+		return "@pemit " + userID + "=Gravybot: the response cache is off.\n"
+	}
+	switch strings.ToLower(strings.TrimSpace(args)) {
+	case "", "stats":
+		return "@pemit " + userID + "=Gravybot: cache " + app.cache.summary() + ".\n"
+	case "clear":
+		return fmt.Sprintf("@pemit %s=Gravybot: cleared %d cached responses.\n", userID, app.cache.clear())
+	}
+	return "@pemit " + userID + "=Gravybot: usage: gravybot cache \\[stats|clear\\]\n"
+}
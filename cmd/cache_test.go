@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newClockedCache returns a cache whose clock is advanced by hand.
+func newClockedCache(max int) (*responseCache, *time.Time) {
+	now := time.Date(2026, 6, 3, 12, 0, 0, 0, time.UTC)
+	ttls, _ := parseCacheTTLs(defaultCacheTTLs)
+	c := newResponseCache(ttls, max)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func counting(calls *int, value string) func() (string, bool, error) {
+	return func() (string, bool, error) {
+		*calls++
+		return fmt.Sprintf("%s %d", value, *calls), true, nil
+	}
+}
+
+func TestResponseCache_TTL(t *testing.T) {
+	c, now := newClockedCache(10)
+	calls := 0
+	for i := 0; i < 3; i++ {
+		if v, _ := cached(c, "weather", "boston", counting(&calls, "sunny")); v != "sunny 1" {
+			t.Fatalf("lookup %d = %q", i, v)
+		}
+	}
+	*now = now.Add(9 * time.Minute)
+	cached(c, "weather", "boston", counting(&calls, "sunny"))
+	if calls != 1 {
+		t.Errorf("fetched %d times within the TTL", calls)
+	}
+	*now = now.Add(2 * time.Minute)
+	if v, _ := cached(c, "weather", "boston", counting(&calls, "sunny")); v != "sunny 2" {
+		t.Errorf("expired entry served: %q", v)
+	}
+	// Quotes go stale much sooner than weather.
+	cached(c, "quotes", "aapl", counting(&calls, "up"))
+	*now = now.Add(31 * time.Second)
+	cached(c, "quotes", "aapl", counting(&calls, "up"))
+	if calls != 4 {
+		t.Errorf("quote TTL not applied: %d fetches", calls)
+	}
+	if got := c.summary(); got != "2/10 entries; quotes 0 hits, 2 misses, 0 coalesced, 0 evicted; weather 3 hits, 2 misses, 0 coalesced, 0 evicted" {
+		t.Errorf("summary %q", got)
+	}
+}
+
+func TestResponseCache_FailuresAndUnkeptValuesNotCached(t *testing.T) {
+	c, _ := newClockedCache(10)
+	calls := 0
+	fail := func() (string, bool, error) { calls++; return "", false, errors.New("boom") }
+	cached(c, "weather", "x", fail)
+	cached(c, "weather", "x", fail)
+
+	reply := func() (string, error) { calls++; return "Stock error: API returned code 429\n", nil }
+	for i := 0; i < 2; i++ {
+		if got, err := cachedReply(c, "quotes", "aapl", "Stock error", reply); err != nil || !strings.HasPrefix(got, "Stock error") {
+			t.Errorf("reply not passed through: %q %v", got, err)
+		}
+	}
+	if calls != 4 {
+		t.Errorf("failures were cached: %d fetches", calls)
+	}
+	var nilCache *responseCache
+	if v, _ := cached(nilCache, "weather", "x", counting(&calls, "v")); v != "v 5" {
+		t.Errorf("nil cache: %q", v)
+	}
+}
+
+func TestResponseCache_LRU(t *testing.T) {
+	c, _ := newClockedCache(2)
+	calls := 0
+	cached(c, "weather", "a", counting(&calls, "a"))
+	cached(c, "weather", "b", counting(&calls, "b"))
+	cached(c, "weather", "a", counting(&calls, "a")) // a is now most recent
+	cached(c, "weather", "c", counting(&calls, "c")) // evicts b
+	cached(c, "weather", "a", counting(&calls, "a"))
+	if calls != 3 {
+		t.Errorf("a was evicted: %d fetches", calls)
+	}
+	cached(c, "weather", "b", counting(&calls, "b"))
+	if calls != 4 || !strings.Contains(c.summary(), "2 evicted") {
+		t.Errorf("b was not evicted: %d fetches, %s", calls, c.summary())
+	}
+}
+
+func TestResponseCache_Coalesces(t *testing.T) {
+	c, _ := newClockedCache(10)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	fetch := func() (string, bool, error) {
+		calls.Add(1)
+		<-release
+		return "quote", true, nil
+	}
+
+	var wg sync.WaitGroup
+	results := make([]string, 5)
+	wg.Add(1)
+	go func() { defer wg.Done(); results[0], _ = cached(c, "quotes", "btc", fetch) }()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	for i := 1; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) { defer wg.Done(); results[i], _ = cached(c, "quotes", "btc", fetch) }(i)
+	}
+	for !strings.Contains(c.summary(), "4 coalesced") {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+	if calls.Load() != 1 || strings.Join(results, ",") != "quote,quote,quote,quote,quote" {
+		t.Errorf("%d fetches, results %q", calls.Load(), results)
+	}
+}
+
+func TestResponseCache_PanicEndsFlight(t *testing.T) {
+	c, _ := newClockedCache(10)
+	var calls atomic.Int32
+	release := make(chan struct{})
+	waited := make(chan error)
+	go func() {
+		defer func() { recover() }()
+		cached(c, "quotes", "btc", func() (string, bool, error) {
+			calls.Add(1)
+			<-release
+			panic("provider bug")
+		})
+	}()
+	for calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	go func() {
+		_, err := cached(c, "quotes", "btc", func() (string, bool, error) { return "unused", true, nil })
+		waited <- err
+	}()
+	for !strings.Contains(c.summary(), "1 coalesced") {
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+
+	select {
+	case err := <-waited:
+		if err == nil {
+			t.Error("waiter on a panicked fetch got no error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("waiter still blocked after the fetch panicked")
+	}
+	if got, err := cached(c, "quotes", "btc", func() (string, bool, error) { return "quote", true, nil }); err != nil || got != "quote" {
+		t.Errorf("after the panic got %q, %v", got, err)
+	}
+}
+
+func TestResponseCache_Persistence(t *testing.T) {
+	dir := t.TempDir()
+	c, now := newClockedCache(10)
+	calls := 0
+	cached(c, "weather", "boston", counting(&calls, "sunny"))
+	cached(c, "quotes", "aapl", counting(&calls, "up"))
+	if err := c.save(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	d, later := newClockedCache(10)
+	*later = now.Add(time.Minute) // the quote has expired, the weather has not
+	n, err := d.load(dir)
+	if err != nil || n != 1 {
+		t.Fatalf("loaded %d, %v", n, err)
+	}
+	if v, _ := cached(d, "weather", "boston", counting(&calls, "sunny")); v != "sunny 1" {
+		t.Errorf("persisted entry not served: %q", v)
+	}
+	if n, err := newResponseCache(nil, 10).load(t.TempDir()); n != 0 || err != nil {
+		t.Errorf("missing file: %d %v", n, err)
+	}
+}
+
+func TestParseCacheTTLs(t *testing.T) {
+	ttls, err := parseCacheTTLs(" Weather=5m, quotes=0s ,")
+	if err != nil || ttls["weather"] != 5*time.Minute || ttls["quotes"] != 0 || len(ttls) != 2 {
+		t.Errorf("got %v, %v", ttls, err)
+	}
+	for _, bad := range []string{"weather", "weather=soon"} {
+		if _, err := parseCacheTTLs(bad); err == nil {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestCachedWeather_OneRoundTrip(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		fmt.Fprintf(w, currentFixture, "Boston", "United States of America")
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.cache, _ = newClockedCache(10)
+
+	first, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather Boston"`)
+	second, _ := app.checkLineForRegexps(`[Ann(#98)] Ann says "gravybot weather -c boston"`)
+	if hits.Load() != 1 {
+		t.Errorf("%d API calls", hits.Load())
+	}
+	if !strings.Contains(first, "75.4F") || !strings.Contains(second, "24.1C") {
+		t.Errorf("units not applied to the cached report: %q %q", first, second)
+	}
+}
+
+func TestCachedAQIAndSun(t *testing.T) {
+	var aqi []string
+	srv := newDetailServer(t, &aqi)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.cache, _ = newClockedCache(10)
+
+	for i := 0; i < 2; i++ {
+		app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot aqi Denver"`)
+		app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun London"`)
+	}
+	if len(aqi) != 1 {
+		t.Errorf("%d air quality calls", len(aqi))
+	}
+	if st := app.cache.stats["weather"]; st.Hits != 2 || st.Misses != 2 {
+		t.Errorf("weather cache stats %+v, want 2 hits and 2 misses", *st)
+	}
+}
+
+func TestCheckLine_CacheCommandInPose(t *testing.T) {
+	app := newTestApp()
+	app.config.admins = "#1"
+	got, _ := app.checkLineForRegexps(`[Dino(#1)] Dino asks gravybot cache stats`)
+	if !strings.Contains(got, "cache is off") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestHandleCache(t *testing.T) {
+	app := newTestApp()
+	app.config.admins = "#1"
+	if got := app.handleCache("#2", ""); !strings.Contains(got, "only admins") {
+		t.Errorf("non-admin: %q", got)
+	}
+	if got := app.handleCache("#1", ""); !strings.Contains(got, "cache is off") {
+		t.Errorf("no cache: %q", got)
+	}
+	app.cache, _ = newClockedCache(10)
+	calls := 0
+	cached(app.cache, "weather", "boston", counting(&calls, "sunny"))
+	if got := app.handleCache("#1", "stats"); got != "@pemit #1=Gravybot: cache 1/10 entries; weather 0 hits, 1 misses, 0 coalesced, 0 evicted.\n" {
+		t.Errorf("stats: %q", got)
+	}
+	if got := app.handleCache("#1", "clear"); got != "@pemit #1=Gravybot: cleared 1 cached responses.\n" {
+		t.Errorf("clear: %q", got)
+	}
+}
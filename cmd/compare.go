@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// defaultScreenWidth is the usual MUSH client width the comparison table is
+// laid out for.
+const defaultScreenWidth = 78
+
+// compareRow is one location in a weather comparison.
+type compareRow struct {
+	report weatherReport
+	cells  [5]string
+}
+
+// compareWeather renders current conditions for several locations as one
+// aligned table in a single unit system, optionally warmest first, with a
+// warmest/coldest summary. Lookups that fail are listed under the table.
+func (app *application) compareWeather(locations []string, units string, byTemp bool) string {
+	var rows []compareRow
+	var problems []string
+	for _, loc := range locations {
+		query := parseLatLon(app.resolveLocation(loc))
+		r, err := app.currentWeather(query)
+		if err != nil {
+			reply, err := app.weatherFailure("Weather", loc, err)
+			if err != nil {
+				reply = "Error: weather api call failed for " + mushEscape(loc) + ".\n"
+			}
+			problems = append(problems, strings.TrimSuffix(reply, "\n"))
+			continue
+		}
+		rows = append(rows, compareRow{report: r})
+	}
+	if len(rows) == 0 {
+		return strings.Join(problems, "%r") + "\n"
+	}
+
+	// One unit system for every row, settled by the first place when the
+	// player has no preference.
+	units = resolveUnits(units, rows[0].report.Place.Country)
+	for i := range rows {
+		r := rows[i].report
+		rows[i].cells = [5]string{
+			r.Place.String(),
+			r.Condition,
+			formatTemp(r.TempC, r.TempF, units),
+			fmt.Sprintf("%.0f%%", r.Humidity),
+			formatSpeed(r.WindKph, r.WindMph, units) + " " + r.WindDir,
+		}
+	}
+	if byTemp {
+		sort.SliceStable(rows, func(i, j int) bool { return rows[i].report.TempC > rows[j].report.TempC })
+	}
+
+	header := [5]string{"Location", "Conditions", "Temp", "Hum", "Wind"}
+	widths := compareWidths(header, rows, app.screenWidth())
+	lines := []string{"Weather comparison:", layoutRow(header, widths)}
+	for _, row := range rows {
+		lines = append(lines, layoutRow(row.cells, widths))
+	}
+	if len(rows) > 1 {
+		warm, cold := rows[0], rows[0]
+		for _, row := range rows[1:] {
+			if row.report.TempC > warm.report.TempC {
+				warm = row
+			}
+			if row.report.TempC < cold.report.TempC {
+				cold = row
+			}
+		}
+		lines = append(lines, fmt.Sprintf("Warmest: %s %s. Coldest: %s %s.",
+			warm.cells[0], warm.cells[2], cold.cells[0], cold.cells[2]))
+	}
+	for i, line := range lines {
+		lines[i] = mushSpaces(strings.ReplaceAll(line, "%", "%%"))
+	}
+	return strings.Join(append(lines, problems...), "%r") + "\n"
+}
+
+func (app *application) screenWidth() int {
+	if app.config.screenWidth > 0 {
+		return app.config.screenWidth
+	}
+	return defaultScreenWidth
+}
+
+// compareWidths sizes the columns to their contents, then narrows the
+// location and conditions columns until the table fits in width.
+func compareWidths(header [5]string, rows []compareRow, width int) [5]int {
+	var w [5]int
+	for i, h := range header {
+		w[i] = utf8.RuneCountInString(h)
+	}
+	for _, row := range rows {
+		for i, c := range row.cells {
+			if n := utf8.RuneCountInString(c); n > w[i] {
+				w[i] = n
+			}
+		}
+	}
+	const gap, minText = 2, 8
+	for {
+		total := gap * (len(w) - 1)
+		for _, n := range w {
+			total += n
+		}
+		if total <= width {
+			return w
+		}
+		widest := 0
+		if w[1] > w[0] {
+			widest = 1
+		}
+		if w[widest] <= minText {
+			return w
+		}
+		w[widest]--
+	}
+}
+
+// layoutRow pads each cell to its column, truncating text that won't fit.
+// Numbers are right-aligned.
+func layoutRow(cells [5]string, widths [5]int) string {
+	parts := make([]string, len(cells))
+	for i, c := range cells {
+		if r := []rune(c); len(r) > widths[i] {
+			c = string(r[:widths[i]-1]) + "~"
+		}
+		if i >= 2 {
+			parts[i] = fmt.Sprintf("%*s", widths[i], c)
+		} else {
+			parts[i] = fmt.Sprintf("%-*s", widths[i], c)
+		}
+	}
+	return strings.TrimRight(strings.Join(parts, "  "), " ")
+}
+
+// mushSpaces keeps runs of spaces from being squeezed by the game by
+// writing all but single spaces as %b.
+func mushSpaces(s string) string {
+	var b strings.Builder
+	run := 0
+	flush := func() {
+		if run == 1 {
+			b.WriteByte(' ')
+		} else {
+			b.WriteString(strings.Repeat("%b", run))
+		}
+		run = 0
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == ' ' {
+			run++
+			continue
+		}
+		flush()
+		b.WriteByte(s[i])
+	}
+	flush()
+	return b.String()
+}
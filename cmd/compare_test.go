@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newCompareServer serves current conditions for a few cities at different
+// temperatures; anything else is not found.
+func newCompareServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	cities := map[string]string{
+		"Miami":  `"name": "Miami", "region": "Florida", "country": "United States of America"}, "current": {"temp_c": 31, "temp_f": 87.8, "humidity": 70, "wind_kph": 20, "wind_mph": 12.4, "wind_dir": "E", "condition": {"text": "Sunny"}`,
+		"Oslo":   `"name": "Oslo", "region": "Oslo", "country": "Norway"}, "current": {"temp_c": 5, "temp_f": 41, "humidity": 81, "wind_kph": 9, "wind_mph": 5.6, "wind_dir": "N", "condition": {"text": "Light rain"}`,
+		"Zurich": `"name": "Zürich", "region": "", "country": "Switzerland"}, "current": {"temp_c": 18, "temp_f": 64.4, "humidity": 60, "wind_kph": 5, "wind_mph": 3.1, "wind_dir": "SW", "condition": {"text": "Patchy light rain with thunder"}`,
+	}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := cities[r.URL.Query().Get("q")]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, `{"location": {%s}}`, body)
+	}))
+}
+
+func TestCheckLine_WeatherTable(t *testing.T) {
+	srv := newCompareServer(t)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather -t Oslo, Miami, nowhere"`)
+	want := "pose W> Weather comparison:" +
+		"%rLocation%b%b%b%b%b%b%b%bConditions%b%b%bTemp%b%bHum%b%b%b%b%b%b%bWind" +
+		"%rOslo, Norway%b%b%b%bLight rain%b%b%b5.0C%b%b81%%%b%b%b9.0kph N" +
+		"%rMiami, Florida%b%bSunny%b%b%b%b%b%b%b31.0C%b%b70%%%b%b20.0kph E" +
+		"%rWarmest: Miami, Florida 31.0C. Coldest: Oslo, Norway 5.0C." +
+		"%rWeather error: nowhere not found. Try using a city state or city country pair.\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCompareWeather_SortUnitsAndWidth(t *testing.T) {
+	srv := newCompareServer(t)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.config.screenWidth = 50
+
+	got := app.compareWeather([]string{"Oslo", "Zurich", "Miami"}, unitsImperial, true)
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "%r")
+	if len(lines) != 6 {
+		t.Fatalf("got %d lines: %q", len(lines), got)
+	}
+	for _, line := range lines[1:5] {
+		plain := strings.NewReplacer("%b", " ", "%%", "%").Replace(line)
+		if n := len([]rune(plain)); n > 50 {
+			t.Errorf("line is %d wide: %q", n, plain)
+		}
+	}
+	for i, city := range []string{"Miami", "Zürich", "Oslo"} {
+		if !strings.HasPrefix(lines[i+2], city) {
+			t.Errorf("row %d is %q, want %s first", i, lines[i+2], city)
+		}
+	}
+	if !strings.Contains(lines[3], "~") || !strings.Contains(lines[4], "41.0F") {
+		t.Errorf("expected truncated conditions and Fahrenheit: %q", lines[3:5])
+	}
+}
+
+func TestMushSpaces(t *testing.T) {
+	for in, want := range map[string]string{"a b": "a b", "a  b": "a%b%bb", "a   ": "a%b%b%b", " a": " a"} {
+		if got := mushSpaces(in); got != want {
+			t.Errorf("mushSpaces(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
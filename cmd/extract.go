@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// defaultURLSchemes are the schemes captured when -urlschemes is not given.
+var defaultURLSchemes = []string{"http", "https", "ftp", "ftps"}
+
+// urlExtractor finds URLs in chat text. A URL starts with one of the
+// configured schemes followed by "://", or with "www.", and runs to the next
+// space or quote. Punctuation that ends the surrounding sentence, and closing
+// brackets with no opening partner inside the URL, are left off the end. A
+// URL wrapped in <angle brackets> runs to the closing bracket.
+type urlExtractor struct {
+	startRe *regexp.Regexp
+}
+
+func newURLExtractor(schemes []string) *urlExtractor {
+	var alts []string
+	for _, s := range schemes {
+		s = strings.ToLower(strings.TrimSuffix(strings.TrimSpace(s), ":"))
+		if s != "" {
+			alts = append(alts, regexp.QuoteMeta(s)+"://")
+		}
+	}
+	alts = append(alts, `www\.`)
+	return &urlExtractor{
+		startRe: regexp.MustCompile(`(?i)(?:^|[^\pL\pN_])(` + strings.Join(alts, "|") + `)`),
+	}
+}
+
+// extract returns the URLs in text in the order they appear, without
+// duplicates.
+func (e *urlExtractor) extract(text string) []string {
+	var out []string
+	seen := map[string]bool{}
+	end := 0
+	for _, m := range e.startRe.FindAllStringSubmatchIndex(text, -1) {
+		start := m[2]
+		if start < end {
+			continue // inside the previous URL, e.g. a redirect parameter
+		}
+		angled := start > 0 && text[start-1] == '<'
+		end = start + scanURL(text[start:])
+		candidate := text[start:end]
+		if !angled {
+			candidate = trimURLTail(candidate)
+		}
+		end = start + len(candidate)
+		if !plausibleURL(candidate) || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+		out = append(out, candidate)
+	}
+	return out
+}
+
+// scanURL returns the length of the run of URL characters at the start of s.
+func scanURL(s string) int {
+	for i, r := range s {
+		if unicode.IsSpace(r) || r == '"' || r == '<' || r == '>' {
+			return i
+		}
+	}
+	return len(s)
+}
+
+// trimURLTail drops sentence punctuation and unbalanced closing brackets from
+// the end of a candidate URL.
+func trimURLTail(s string) string {
+	for s != "" {
+		r, size := utf8.DecodeLastRuneInString(s)
+		switch {
+		case strings.ContainsRune(".,;:!?'*", r):
+		case r == ')' && strings.Count(s, ")") > strings.Count(s, "("):
+		case r == ']' && strings.Count(s, "]") > strings.Count(s, "["):
+		case r == '}' && strings.Count(s, "}") > strings.Count(s, "{"):
+		case r >= utf8.RuneSelf && unicode.IsPunct(r):
+		default:
+			return s
+		}
+		s = s[:len(s)-size]
+	}
+	return s
+}
+
+// plausibleURL rejects candidates with no usable host, such as a bare
+// "http://" or "www." at the end of a sentence.
+func plausibleURL(s string) bool {
+	raw := s
+	if strings.HasPrefix(strings.ToLower(s), "www.") {
+		raw = "http://" + s
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return false
+	}
+	host := u.Hostname()
+	if host == "" || strings.HasPrefix(host, ".") || strings.Contains(host, "..") {
+		return false
+	}
+	if raw != s {
+		// "www.example" alone is too likely to be prose.
+		return strings.Count(strings.TrimSuffix(host, "."), ".") >= 2
+	}
+	return true
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestURLExtractor_Corpus(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		// plain
+		{"bare", "https://example.com", []string{"https://example.com"}},
+		{"in sentence", "look at https://example.com/a today", []string{"https://example.com/a"}},
+		{"http", "http://example.com/", []string{"http://example.com/"}},
+		{"ftp", "grab ftp://ftp.example.org/pub/file.tgz", []string{"ftp://ftp.example.org/pub/file.tgz"}},
+		{"ftps", "ftps://files.example.org/x", []string{"ftps://files.example.org/x"}},
+		{"uppercase scheme", "HTTPS://EXAMPLE.COM/A", []string{"HTTPS://EXAMPLE.COM/A"}},
+		{"www", "try www.example.com for more", []string{"www.example.com"}},
+		{"www with path", "www.example.co.uk/path?q=1", []string{"www.example.co.uk/path?q=1"}},
+		{"port", "http://localhost:8080/status", []string{"http://localhost:8080/status"}},
+		{"ip", "http://192.0.2.1/x", []string{"http://192.0.2.1/x"}},
+		{"ipv6", "http://[2001:db8::1]:80/x", []string{"http://[2001:db8::1]:80/x"}},
+		{"userinfo", "ftp://anon@ftp.example.org/", []string{"ftp://anon@ftp.example.org/"}},
+		{"query and fragment", "https://example.com/a?b=c&d=e#frag", []string{"https://example.com/a?b=c&d=e#frag"}},
+		{"percent escapes", "https://example.com/a%20b", []string{"https://example.com/a%20b"}},
+		{"two urls", "https://a.example and https://b.example", []string{"https://a.example", "https://b.example"}},
+		{"duplicates collapsed", "https://a.example https://a.example", []string{"https://a.example"}},
+		{"start of line", "https://a.example is good", []string{"https://a.example"}},
+
+		// trailing punctuation
+		{"period", "See https://example.com/a.", []string{"https://example.com/a"}},
+		{"comma", "https://example.com/a, and more", []string{"https://example.com/a"}},
+		{"semicolon", "https://example.com/a; then", []string{"https://example.com/a"}},
+		{"colon", "here it is https://example.com/a:", []string{"https://example.com/a"}},
+		{"exclamation", "wow https://example.com/a!", []string{"https://example.com/a"}},
+		{"question", "have you seen https://example.com/a?", []string{"https://example.com/a"}},
+		{"ellipsis", "hmm https://example.com/a...", []string{"https://example.com/a"}},
+		{"apostrophe", "'https://example.com/a'", []string{"https://example.com/a"}},
+		{"emphasis", "*https://example.com/a*", []string{"https://example.com/a"}},
+		{"period inside path kept", "https://example.com/file.tar.gz", []string{"https://example.com/file.tar.gz"}},
+		{"query kept before period", "https://example.com/?q=1.", []string{"https://example.com/?q=1"}},
+		{"trailing slash kept", "https://example.com/a/.", []string{"https://example.com/a/"}},
+		{"unicode full stop", "見て https://example.com/a。", []string{"https://example.com/a"}},
+		{"curly quotes", "“https://example.com/a”", []string{"https://example.com/a"}},
+
+		// brackets
+		{"wrapped in parens", "(see https://x.org/a)", []string{"https://x.org/a"}},
+		{"parens then period", "(https://x.org/a).", []string{"https://x.org/a"}},
+		{"balanced parens kept", "https://en.wikipedia.org/wiki/Mercury_(planet)", []string{"https://en.wikipedia.org/wiki/Mercury_(planet)"}},
+		{"balanced inside parens", "(https://en.wikipedia.org/wiki/Mercury_(planet))", []string{"https://en.wikipedia.org/wiki/Mercury_(planet)"}},
+		{"balanced then comma", "https://en.wikipedia.org/wiki/Mercury_(planet), right", []string{"https://en.wikipedia.org/wiki/Mercury_(planet)"}},
+		{"square brackets", "[https://x.org/a]", []string{"https://x.org/a"}},
+		{"braces", "{https://x.org/a}", []string{"https://x.org/a"}},
+		{"balanced square kept", "https://x.org/a[1]", []string{"https://x.org/a[1]"}},
+		{"separate parens", "(https://a.example) (https://b.example)", []string{"https://a.example", "https://b.example"}},
+
+		// angle brackets
+		{"angle", "<https://example.com/a>", []string{"https://example.com/a"}},
+		{"angle keeps punctuation", "<https://example.com/a.>", []string{"https://example.com/a."}},
+		{"angle keeps parens", "<https://example.com/a)>", []string{"https://example.com/a)"}},
+		{"angle in sentence", "go to <https://example.com/a>.", []string{"https://example.com/a"}},
+		{"html-ish", "<a href=\"https://example.com/a\">", []string{"https://example.com/a"}},
+
+		// quotes
+		{"double quoted", `he said "https://example.com/a" twice`, []string{"https://example.com/a"}},
+		{"quote ends url", `https://example.com/a"b`, []string{"https://example.com/a"}},
+
+		// IDN
+		{"idn host", "https://bücher.example/katalog", []string{"https://bücher.example/katalog"}},
+		{"idn www", "www.bücher.example.de/", []string{"www.bücher.example.de/"}},
+		{"idn then punctuation", "visit https://пример.испытание.", []string{"https://пример.испытание"}},
+		{"cjk path", "https://example.jp/日本語", []string{"https://example.jp/日本語"}},
+		{"punycode", "https://xn--bcher-kva.example/", []string{"https://xn--bcher-kva.example/"}},
+
+		// nested
+		{"redirect parameter", "https://a.example/r?to=http://b.example/x", []string{"https://a.example/r?to=http://b.example/x"}},
+
+		// not URLs
+		{"telnet token", "connect telnet:dino.surly.org 6250", nil},
+		{"telnet url not default", "telnet://dino.surly.org:6250", nil},
+		{"ssh token", "ssh: connection refused", nil},
+		{"ssh url not default", "ssh://git@example.com/repo", nil},
+		{"mailto", "mailto:someone@example.com", nil},
+		{"bare scheme", "http:// is a prefix", nil},
+		{"scheme only", "https://", nil},
+		{"scheme without slashes", "https:example.com", nil},
+		{"www alone", "the www. prefix", nil},
+		{"www one label", "www.example is not enough", nil},
+		{"embedded in word", "xhttps://example.com", nil},
+		{"underscore prefix", "_https://example.com", nil},
+		{"empty", "", nil},
+		{"no urls", "just chatting about http and www", nil},
+		{"dotted host", "https://..example.com", nil},
+	}
+	e := newURLExtractor(defaultURLSchemes)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := e.extract(tt.text)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("extract(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestURLExtractor_ConfiguredSchemes(t *testing.T) {
+	e := newURLExtractor(strings.Split("https, telnet:, gopher", ","))
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"telnet://dino.surly.org:6250", []string{"telnet://dino.surly.org:6250"}},
+		{"gopher://gopher.floodgap.com/1/", []string{"gopher://gopher.floodgap.com/1/"}},
+		{"http://example.com", nil},
+		{"telnet:dino.surly.org", nil},
+		{"www.example.com", []string{"www.example.com"}},
+	}
+	for _, tt := range tests {
+		if got := e.extract(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("extract(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestCheckLine_URLExtractedWithoutPunctuation(t *testing.T) {
+	app := newTestApp()
+	srv := newYirpServer(t)
+	defer srv.Close()
+	app.config.yirpAPIAddr = srv.URL
+
+	next := startURLCapture(t, app)
+
+	app.checkLineForRegexps(`[Rex(#99)] Rex says "(see https://x.org/a), then telnet:dino.surly.org."`)
+	got := next()
+	if !strings.Contains(got, "add_url #99 https://y.rp/s1 https://x.org/a\n") || strings.Count(got, "add_url") != 1 {
+		t.Errorf("unexpected output %q", got)
+	}
+}
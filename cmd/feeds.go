@@ -0,0 +1,180 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// feedEntries is how many of the most recent URLs a feed carries.
+const feedEntries = 50
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	Title   string      `xml:"title"`
+	ID      string      `xml:"id"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr,omitempty"`
+	Href string `xml:"href,attr"`
+}
+
+type atomEntry struct {
+	Title   string     `xml:"title"`
+	ID      string     `xml:"id"`
+	Updated string     `xml:"updated"`
+	Link    atomLink   `xml:"link"`
+	Author  atomAuthor `xml:"author"`
+	Summary string     `xml:"summary"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	DC      string     `xml:"xmlns:dc,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title       string  `xml:"title"`
+	Link        string  `xml:"link"`
+	GUID        rssGUID `xml:"guid"`
+	PubDate     string  `xml:"pubDate"`
+	Creator     string  `xml:"dc:creator"`
+	Description string  `xml:"description"`
+}
+
+type rssGUID struct {
+	PermaLink bool   `xml:"isPermaLink,attr"`
+	Value     string `xml:",chardata"`
+}
+
+// feedRecords returns the newest captured URLs, limited to one room or
+// channel when the request names one with ?room=#123 or ?channel=Public.
+func (app *application) feedRecords(r *http.Request) (string, []urlRecord, error) {
+	where, label := "", "all rooms"
+	if room := r.URL.Query().Get("room"); room != "" {
+		where = "#" + strings.TrimPrefix(room, "#")
+		label = "room " + where
+	} else if ch := r.URL.Query().Get("channel"); ch != "" {
+		where, label = "channel:"+ch, "channel "+ch
+	}
+	recs, err := app.urls.find(func(rec urlRecord) bool {
+		return where == "" || rec.Where == where
+	})
+	if len(recs) > feedEntries {
+		recs = recs[:feedEntries]
+	}
+	return label, recs, err
+}
+
+func feedEntryTitle(rec urlRecord) string {
+	if rec.Title != "" {
+		return rec.Title
+	}
+	return rec.Long
+}
+
+func feedEntrySummary(rec urlRecord) string {
+	s := fmt.Sprintf("Posted by %s", rec.Name)
+	if rec.Where != "" {
+		s += " in " + strings.TrimPrefix(rec.Where, "channel:")
+	}
+	if rec.Short != rec.Long {
+		s += ": " + rec.Short
+	}
+	return s
+}
+
+// serveAtom publishes captured URLs as an Atom feed.
+func (app *application) serveAtom(w http.ResponseWriter, r *http.Request) {
+	label, recs, err := app.feedRecords(r)
+	if err != nil {
+		app.errorLog.Printf("atom feed: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	base := app.linkBase()
+	self := base + r.URL.RequestURI()
+
+	feed := atomFeed{
+		Title:   app.config.botName + " links: " + label,
+		ID:      self,
+		Updated: time.Unix(0, 0).UTC().Format(time.RFC3339),
+		Links:   []atomLink{{Rel: "self", Href: self}, {Href: base + "/"}},
+	}
+	for i, rec := range recs {
+		posted := rec.Posted.UTC().Format(time.RFC3339)
+		if i == 0 {
+			feed.Updated = posted
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			Title:   feedEntryTitle(rec),
+			ID:      fmt.Sprintf("%s/feed/%d", base, rec.ID),
+			Updated: posted,
+			Link:    atomLink{Href: rec.Long},
+			Author:  atomAuthor{Name: rec.Name},
+			Summary: feedEntrySummary(rec),
+		})
+	}
+	app.writeFeed(w, "application/atom+xml", feed)
+}
+
+// serveRSS publishes captured URLs as an RSS 2.0 feed.
+func (app *application) serveRSS(w http.ResponseWriter, r *http.Request) {
+	label, recs, err := app.feedRecords(r)
+	if err != nil {
+		app.errorLog.Printf("rss feed: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	base := app.linkBase()
+
+	feed := rssFeed{
+		Version: "2.0",
+		DC:      "http://purl.org/dc/elements/1.1/",
+		Channel: rssChannel{
+			Title:       app.config.botName + " links: " + label,
+			Link:        base + "/",
+			Description: "URLs shared in the game",
+		},
+	}
+	for _, rec := range recs {
+		feed.Channel.Items = append(feed.Channel.Items, rssItem{
+			Title:       feedEntryTitle(rec),
+			Link:        rec.Long,
+			GUID:        rssGUID{Value: fmt.Sprintf("%s/feed/%d", base, rec.ID)},
+			PubDate:     rec.Posted.UTC().Format(time.RFC1123Z),
+			Creator:     rec.Name,
+			Description: feedEntrySummary(rec),
+		})
+	}
+	app.writeFeed(w, "application/rss+xml", feed)
+}
+
+func (app *application) writeFeed(w http.ResponseWriter, contentType string, feed interface{}) {
+	w.Header().Set("Content-Type", contentType+"; charset=utf-8")
+	w.Write([]byte(xml.Header))
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(feed); err != nil {
+		app.errorLog.Printf("feed: %s", err)
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newFeedServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	app := newTestApp()
+	app.config.localBaseURL = "https://go.example"
+	posted := time.Date(2026, 6, 3, 12, 0, 0, 0, time.UTC)
+	for i, rec := range []urlRecord{
+		{Poster: "#99", Name: "Rex", Where: "#20", Short: "https://go.example/abc", Long: "https://example.com/a", Title: "Rex & Friends"},
+		{Poster: "#1234", Name: "Dino", Where: "channel:Public", Short: "https://example.com/b", Long: "https://example.com/b"},
+		{Poster: "#99", Name: "Rex", Where: "#21", Short: "https://go.example/def", Long: "https://example.com/c?x=1&y=2"},
+	} {
+		rec.Posted = posted.Add(time.Duration(i) * time.Hour)
+		if _, err := app.urls.add(rec); err != nil {
+			t.Fatal(err)
+		}
+	}
+	srv := httptest.NewServer(app.routes())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func getFeed(t *testing.T, srv *httptest.Server, path string) (string, string) {
+	t.Helper()
+	res, err := http.Get(srv.URL + path)
+	if err != nil {
+		t.Fatalf("get %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("get %s: status %d", path, res.StatusCode)
+	}
+	body, _ := io.ReadAll(res.Body)
+	return res.Header.Get("Content-Type"), string(body)
+}
+
+func TestServeAtom(t *testing.T) {
+	srv := newFeedServer(t)
+	ct, body := getFeed(t, srv, "/feed.atom")
+	if !strings.HasPrefix(ct, "application/atom+xml") {
+		t.Errorf("content type %q", ct)
+	}
+
+	var feed atomFeed
+	if err := xml.Unmarshal([]byte(body), &feed); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, body)
+	}
+	if len(feed.Entries) != 3 || feed.Updated != "2026-06-03T14:00:00Z" {
+		t.Fatalf("unexpected feed %+v", feed)
+	}
+	first, last := feed.Entries[0], feed.Entries[2]
+	if first.Link.Href != "https://example.com/c?x=1&y=2" || first.Author.Name != "Rex" || first.ID != "https://go.example/feed/3" {
+		t.Errorf("unexpected newest entry %+v", first)
+	}
+	if last.Title != "Rex & Friends" || last.Summary != "Posted by Rex in #20: https://go.example/abc" {
+		t.Errorf("unexpected oldest entry %+v", last)
+	}
+	if feed.Entries[1].Title != "https://example.com/b" || feed.Entries[1].Summary != "Posted by Dino in Public" {
+		t.Errorf("untitled entry %+v", feed.Entries[1])
+	}
+}
+
+func TestServeAtom_Filtered(t *testing.T) {
+	srv := newFeedServer(t)
+
+	_, body := getFeed(t, srv, "/feed.atom?room="+url.QueryEscape("#20"))
+	var feed atomFeed
+	xml.Unmarshal([]byte(body), &feed)
+	if len(feed.Entries) != 1 || feed.Entries[0].Link.Href != "https://example.com/a" || !strings.HasSuffix(feed.Title, "room #20") {
+		t.Errorf("room filter: %+v", feed)
+	}
+
+	_, body = getFeed(t, srv, "/feed.atom?channel=Public")
+	feed = atomFeed{}
+	xml.Unmarshal([]byte(body), &feed)
+	if len(feed.Entries) != 1 || feed.Entries[0].Author.Name != "Dino" {
+		t.Errorf("channel filter: %+v", feed)
+	}
+
+	_, body = getFeed(t, srv, "/feed.atom?room=999")
+	feed = atomFeed{}
+	xml.Unmarshal([]byte(body), &feed)
+	if len(feed.Entries) != 0 || feed.Updated != "1970-01-01T00:00:00Z" {
+		t.Errorf("empty feed: %+v", feed)
+	}
+}
+
+func TestServeRSS(t *testing.T) {
+	srv := newFeedServer(t)
+	ct, body := getFeed(t, srv, "/feed.rss?room=21")
+	if !strings.HasPrefix(ct, "application/rss+xml") {
+		t.Errorf("content type %q", ct)
+	}
+	if err := xml.Unmarshal([]byte(body), new(interface{})); err != nil {
+		t.Fatalf("invalid XML: %v\n%s", err, body)
+	}
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		"<title>gravybot links: room #21</title>",
+		"<link>https://example.com/c?x=1&amp;y=2</link>",
+		`<guid isPermaLink="false">https://go.example/feed/3</guid>`,
+		"<pubDate>Wed, 03 Jun 2026 14:00:00 +0000</pubDate>",
+		"<dc:creator>Rex</dc:creator>",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("feed missing %s:\n%s", want, body)
+		}
+	}
+	if strings.Count(body, "<item>") != 1 {
+		t.Errorf("expected one item:\n%s", body)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	defaultForecastDays = 3
+	maxForecastDays     = 7
+)
+
+type WeatherAPIForecastResponse struct {
+	Location struct {
+		Name    string `json:"name"`
+		Region  string `json:"region"`
+		Country string `json:"country"`
+	} `json:"location"`
+
+	Forecast struct {
+		Forecastday []struct {
+			Date string `json:"date"`
+			Day  struct {
+				Maxtemp_c            float64 `json:"maxtemp_c"`
+				Maxtemp_f            float64 `json:"maxtemp_f"`
+				Mintemp_c            float64 `json:"mintemp_c"`
+				Mintemp_f            float64 `json:"mintemp_f"`
+				Totalprecip_mm       float64 `json:"totalprecip_mm"`
+				Totalprecip_in       float64 `json:"totalprecip_in"`
+				Daily_chance_of_rain int     `json:"daily_chance_of_rain"`
+				Daily_chance_of_snow int     `json:"daily_chance_of_snow"`
+				Condition            struct {
+					Text string `json:"text"`
+				} `json:"condition"`
+			} `json:"day"`
+		} `json:"forecastday"`
+	} `json:"forecast"`
+}
+
+// forecastArgsRe splits "<locations> [days]".
+var forecastArgsRe = regexp.MustCompile(`^(.*?)(?:\s+(\d{1,2}))?$`)
+
+// parseForecastArgs returns the location list and day count from the text
+// after "forecast". A trailing number is only taken as the day count when it
+// is in range, so "40 74" stays a pair of coordinates.
+func parseForecastArgs(args string) (string, int) {
+	args = strings.TrimSpace(args)
+	m := forecastArgsRe.FindStringSubmatch(args)
+	if m == nil || m[2] == "" {
+		return args, defaultForecastDays
+	}
+	days, _ := strconv.Atoi(m[2])
+	if days < 1 || days > maxForecastDays || strings.TrimSpace(m[1]) == "" {
+		return args, defaultForecastDays
+	}
+	return strings.TrimSpace(m[1]), days
+}
+
+// sendForecastRequest returns a daily forecast for loc, one line per day
+// joined with %r. units is "metric", "imperial" or "both"; when empty the
+// units follow the location's country.
+func (app *application) sendForecastRequest(loc, units string, days int) (string, error) {
+	key := "forecast|" + strings.ToLower(loc) + "|" + strconv.Itoa(days)
+	f, err := cached(app.cache, "weather", key, func() (weatherForecast, bool, error) {
+		f, err := app.weather.forecast(loc, days)
+		return f, true, err
+	})
+	if err != nil {
+		return app.weatherFailure("Forecast", loc, err)
+	}
+	if len(f.Days) == 0 {
+		return "Forecast error: no forecast for " + mushEscape(loc) + "\n", nil
+	}
+	return formatForecast(f, units), nil
+}
+
+// handleForecast implements "gravybot forecast <location>[,<location>...] [days]",
+// falling back to the player's saved location.
+func (app *application) handleForecast(userID, args string) string {
+	args, requested := parseUnitFlags(args)
+	units := app.chooseUnits(requested, userID)
+	where, days := parseForecastArgs(args)
+	locations := app.placesFor(userID, where)
+	if len(locations) == 0 {
+		return "@pemit " + userID + "=Gravybot: no location given. Try: gravybot set location <place>\n"
+	}
+	var commands []string
+	for _, loc := range locations {
+		response, err := app.sendForecastRequest(parseLatLon(app.resolveLocation(loc)), units, days)
+		if err != nil {
+			response = "Error: forecast api call failed.\n"
+		}
+		commands = append(commands, "pose F> "+response)
+	}
+	return strings.Join(commands, "")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const forecastFixture = `{
+  "location": {"name": "%s", "region": "Massachusetts", "country": "%s"},
+  "forecast": {"forecastday": [
+    {"date": "2026-06-03", "day": {"maxtemp_c": 24.1, "maxtemp_f": 75.4, "mintemp_c": 14.6, "mintemp_f": 58.3,
+      "daily_chance_of_rain": 20, "daily_chance_of_snow": 0, "condition": {"text": "Partly cloudy"}}},
+    {"date": "2026-06-04", "day": {"maxtemp_c": 18, "maxtemp_f": 64.4, "mintemp_c": 11, "mintemp_f": 51.8,
+      "daily_chance_of_rain": 85, "daily_chance_of_snow": 0, "condition": {"text": "Moderate rain"}}},
+    {"date": "2026-06-05", "day": {"maxtemp_c": -1, "maxtemp_f": 30.2, "mintemp_c": -6, "mintemp_f": 21.2,
+      "daily_chance_of_rain": 10, "daily_chance_of_snow": 60, "condition": {"text": "Light snow"}}}
+  ]}
+}`
+
+// newForecastServer serves forecastFixture, recording the queries it saw.
+// "nowhere" gets a 400 like the real API.
+func newForecastServer(t *testing.T, queries *[]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/forecast.json" {
+			http.NotFound(w, r)
+			return
+		}
+		q := r.URL.Query()
+		*queries = append(*queries, q.Get("q")+" days="+q.Get("days"))
+		switch q.Get("q") {
+		case "nowhere":
+			w.WriteHeader(http.StatusBadRequest)
+		case "London":
+			fmt.Fprintf(w, forecastFixture, "London", "United Kingdom")
+		default:
+			fmt.Fprintf(w, forecastFixture, "Boston", "United States of America")
+		}
+	}))
+}
+
+func TestParseForecastArgs(t *testing.T) {
+	tests := []struct {
+		args  string
+		where string
+		days  int
+	}{
+		{"", "", 3},
+		{"Boston", "Boston", 3},
+		{"Boston 5", "Boston", 5},
+		{"Boston, London 2", "Boston, London", 2},
+		{"5", "5", 3},
+		{"Boston 30", "Boston 30", 3},
+		{"40 74", "40 74", 3},
+		{"40.7 -74.0", "40.7 -74.0", 3},
+		{"40.7:-74.0 7", "40.7:-74.0", 7},
+	}
+	for _, tt := range tests {
+		where, days := parseForecastArgs(tt.args)
+		if where != tt.where || days != tt.days {
+			t.Errorf("parseForecastArgs(%q) = %q, %d; want %q, %d", tt.args, where, days, tt.where, tt.days)
+		}
+	}
+}
+
+func TestHandleForecast(t *testing.T) {
+	var queries []string
+	srv := newForecastServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got := app.handleForecast("#42", "Boston 3")
+	want := "pose F> Boston, Massachusetts:" +
+		"%rWed Jun 3: Partly cloudy 75/58F 20%% rain" +
+		"%rThu Jun 4: Moderate rain 64/52F 85%% rain" +
+		"%rFri Jun 5: Light snow 30/21F 60%% snow\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if queries[0] != "Boston days=3" {
+		t.Errorf("query %q", queries[0])
+	}
+}
+
+func TestHandleForecast_MultiLocationAndCoordinates(t *testing.T) {
+	var queries []string
+	srv := newForecastServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got := app.handleForecast("#42", "London, 42.36:-71.06, nowhere 2")
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected 3 poses, got %q", got)
+	}
+	if !strings.HasPrefix(lines[0], "pose F> London, United Kingdom:%rWed Jun 3: Partly cloudy 24/15C") {
+		t.Errorf("metric outside the US: %q", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "pose F> Boston, Massachusetts:") {
+		t.Errorf("coordinates: %q", lines[1])
+	}
+	if lines[2] != "pose F> Forecast error: nowhere not found. Try using a city state or city country pair." {
+		t.Errorf("not found: %q", lines[2])
+	}
+	if strings.Join(queries, "|") != "London days=2|42.36,-71.06 days=2|nowhere days=2" {
+		t.Errorf("queries %q", queries)
+	}
+}
+
+func TestHandleForecast_PrefsAndUnits(t *testing.T) {
+	var queries []string
+	srv := newForecastServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	if got := app.handleForecast("#42", ""); !strings.Contains(got, "no location given") {
+		t.Errorf("no location: %q", got)
+	}
+	app.prefs.set("#42", "location", "Boston")
+	app.prefs.set("#42", "units", "metric")
+	got := app.handleForecast("#42", "")
+	if !strings.Contains(got, "Partly cloudy 24/15C") || queries[0] != "Boston days=3" {
+		t.Errorf("saved location and units not used: %q %q", got, queries)
+	}
+}
+
+func TestCheckLine_Forecast(t *testing.T) {
+	var queries []string
+	srv := newForecastServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex wonders, "gravybot forecast Boston 1?"`)
+	if !strings.HasPrefix(got, "pose F> Boston") || len(queries) != 1 || queries[0] != "Boston days=1" {
+		t.Errorf("got %q, queries %q", got, queries)
+	}
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHistoryDays is how far back "weather <location> on <date>" looks
+// unless -historydays says otherwise.
+const defaultHistoryDays = 3650
+
+var (
+	// historyRe splits "<locations> on <date>" at the last "on", so places
+	// like "Stratford on Avon" survive when what follows isn't a date.
+	historyRe = regexp.MustCompile(`(?i)^(?:(.*)\s+)?on\s+(\S.*)$`)
+	agoRe     = regexp.MustCompile(`^(\d+|a|an|one) (day|week|month|year)s? ago$`)
+	ordinalRe = regexp.MustCompile(`\b(\d{1,2})(?:st|nd|rd|th)\b`)
+)
+
+var errNotADate = errors.New("not a date")
+
+// historyDateLayouts are the spelled-out dates parseHistoryDate accepts,
+// after commas and ordinals are dropped. Numeric dates other than ISO are
+// left out since players don't agree on day/month order.
+var historyDateLayouts = []string{
+	"2006-01-02", "2006/01/02",
+	"Jan 2 2006", "January 2 2006", "2 Jan 2006", "2 January 2006",
+}
+
+// historyYearlessLayouts are dates without a year, taken as the most recent
+// such day.
+var historyYearlessLayouts = []string{"Jan 2", "January 2", "2 Jan", "2 January"}
+
+// parseHistoryDate reads a past date relative to now: ISO dates, spelled-out
+// dates with or without a year, "yesterday", "N days ago", "last friday" or
+// just "friday". The result is midnight UTC of that day. Text that isn't a
+// date gives errNotADate; a date that doesn't exist, like Feb 30, another
+// error.
+func parseHistoryDate(s string, now time.Time) (time.Time, error) {
+	s = strings.ToLower(strings.Join(strings.Fields(strings.ReplaceAll(s, ",", " ")), " "))
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch s {
+	case "today":
+		return today, nil
+	case "yesterday":
+		return today.AddDate(0, 0, -1), nil
+	}
+	if m := agoRe.FindStringSubmatch(s); m != nil {
+		n, err := strconv.Atoi(m[1])
+		if err != nil {
+			n = 1
+		}
+		switch m[2] {
+		case "day":
+			return today.AddDate(0, 0, -n), nil
+		case "week":
+			return today.AddDate(0, 0, -7*n), nil
+		case "month":
+			return today.AddDate(0, -n, 0), nil
+		}
+		return today.AddDate(-n, 0, 0), nil
+	}
+	if wd, ok := parseWeekday(strings.TrimPrefix(s, "last ")); ok {
+		back := (int(today.Weekday()) - int(wd) + 7) % 7
+		if back == 0 {
+			back = 7
+		}
+		return today.AddDate(0, 0, -back), nil
+	}
+	s = ordinalRe.ReplaceAllString(s, "$1")
+	for _, layout := range historyDateLayouts {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return t, nil
+		}
+		if dayOutOfRange(err) {
+			return time.Time{}, fmt.Errorf("%s isn't a real date", s)
+		}
+	}
+	for _, layout := range historyYearlessLayouts {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			if dayOutOfRange(err) {
+				return time.Time{}, fmt.Errorf("%s isn't a real date", s)
+			}
+			continue
+		}
+		year := today.Year()
+		if time.Date(year, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).After(today) {
+			year--
+		}
+		// Feb 29 parses (as year 0, a leap year) but is only a day in leap
+		// years; time.Date would roll it over to Mar 1.
+		d := time.Date(year, t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		if d.Day() != t.Day() {
+			return time.Time{}, fmt.Errorf("%s wasn't a day in %d", s, year)
+		}
+		return d, nil
+	}
+	return time.Time{}, errNotADate
+}
+
+// dayOutOfRange reports whether time.Parse failed on a day the month
+// doesn't have, such as April 31.
+func dayOutOfRange(err error) bool {
+	return strings.HasSuffix(err.Error(), "day out of range")
+}
+
+func parseWeekday(s string) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || s == name[:3] {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+func (app *application) historyDays() int {
+	if app.config.historyDays > 0 {
+		return app.config.historyDays
+	}
+	return defaultHistoryDays
+}
+
+// weatherOn handles "weather <locations> on <date>", reporting false when
+// where doesn't end in a date so it can be treated as a plain location.
+// "here" is the place mapped to room, as for "weather here".
+func (app *application) weatherOn(userID, where, room, units string, now time.Time) (string, bool) {
+	m := historyRe.FindStringSubmatch(where)
+	if m == nil {
+		return "", false
+	}
+	date, err := parseHistoryDate(m[2], now)
+	if errors.Is(err, errNotADate) {
+		return "", false
+	}
+	if err != nil {
+		return "@pemit " + userID + "=Gravybot: " + mushEscape(err.Error()) + ".\n", true
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	if date.After(today) {
+		return "@pemit " + userID + "=Gravybot: that hasn't happened yet. Try: gravybot forecast <location>\n", true
+	}
+	if limit := app.historyDays(); today.Sub(date) > time.Duration(limit)*24*time.Hour {
+		return fmt.Sprintf("@pemit %s=Gravybot: I can only look back %d days.\n", userID, limit), true
+	}
+
+	where = strings.TrimSpace(m[1])
+	if strings.EqualFold(where, "here") {
+		return app.weatherHere(userID, room, hereRequest{units: units, date: date}), true
+	}
+	locations := app.placesFor(userID, where)
+	if len(locations) == 0 {
+		return "@pemit " + userID + "=Gravybot: no location given. Try: gravybot set location <place>\n", true
+	}
+	var commands []string
+	for _, loc := range locations {
+		response, err := app.sendHistoryRequest(parseLatLon(app.resolveLocation(loc)), date, units)
+		if err != nil {
+			response = "Error: weather api call failed.\n"
+		}
+		commands = append(commands, "pose W> "+response)
+	}
+	return strings.Join(commands, ""), true
+}
+
+// sendHistoryRequest reports the weather at loc on a past day. Past days
+// don't change, so they are cached for a long time, except the last couple
+// which providers may still be filling in.
+func (app *application) sendHistoryRequest(loc string, date time.Time, units string) (string, error) {
+	day := date.Format("2006-01-02")
+	f, err := cached(app.cache, "history", strings.ToLower(loc)+"|"+day, func() (weatherForecast, bool, error) {
+		f, err := app.weather.history(loc, date)
+		return f, time.Since(date) > 48*time.Hour, err
+	})
+	if err != nil {
+		return app.weatherFailure("History", loc, err)
+	}
+	if len(f.Days) == 0 {
+		return "History error: no data for " + mushEscape(loc) + " on " + day + ".\n", nil
+	}
+	return formatHistory(f.Place, f.Days[0], units), nil
+}
+
+// formatHistory renders one past day on one line.
+func formatHistory(place weatherPlace, d forecastDay, units string) string {
+	units = resolveUnits(units, place.Country)
+	return fmt.Sprintf("%v on %s: %s %s, precip %s\n", place, d.Date.Format("Mon Jan 2 2006"), d.Condition,
+		formatTempRange(d.MaxC, d.MinC, d.MaxF, d.MinF, units), formatPrecip(d.PrecipMm, d.PrecipIn, units))
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseHistoryDate(t *testing.T) {
+	now := time.Date(2026, 10, 18, 15, 4, 0, 0, time.UTC) // a Sunday
+	tests := []struct {
+		in, want string
+	}{
+		{"2024-07-04", "2024-07-04"},
+		{"2024/07/04", "2024-07-04"},
+		{"yesterday", "2026-10-17"},
+		{"Today", "2026-10-18"},
+		{"3 days ago", "2026-10-15"},
+		{"a week ago", "2026-10-11"},
+		{"2 years ago", "2024-10-18"},
+		{"last friday", "2026-10-16"},
+		{"friday", "2026-10-16"},
+		{"last sunday", "2026-10-11"},
+		{"sat", "2026-10-17"},
+		{"July 4, 2024", "2024-07-04"},
+		{"jul 4th 2024", "2024-07-04"},
+		{"4 July 2024", "2024-07-04"},
+		{"October 1", "2026-10-01"},
+		{"Dec 25", "2025-12-25"},
+	}
+	for _, tt := range tests {
+		got, err := parseHistoryDate(tt.in, now)
+		if err != nil || got.Format("2006-01-02") != tt.want {
+			t.Errorf("parseHistoryDate(%q) = %v, %v; want %s", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"Avon", "the moon", "13/13/2024", "last week"} {
+		if _, err := parseHistoryDate(in, now); err == nil {
+			t.Errorf("parseHistoryDate(%q) parsed", in)
+		}
+	}
+	// Days that don't exist are refused rather than rolled into March.
+	for _, in := range []string{"feb 29", "Feb 29 2023", "2025-02-29", "April 31"} {
+		if _, err := parseHistoryDate(in, now); err == nil || errors.Is(err, errNotADate) {
+			t.Errorf("parseHistoryDate(%q) = %v, want a no-such-day error", in, err)
+		}
+	}
+	if got, err := parseHistoryDate("Feb 29 2024", now); err != nil || got.Format("2006-01-02") != "2024-02-29" {
+		t.Errorf("leap day: %v, %v", got, err)
+	}
+}
+
+const historyFixture = `{
+  "location": {"name": "Denver", "region": "Colorado", "country": "United States of America"},
+  "forecast": {"forecastday": [{"date": "%s", "day": {"maxtemp_c": 24, "maxtemp_f": 75.2, "mintemp_c": 14,
+    "mintemp_f": 57.2, "totalprecip_mm": 3, "totalprecip_in": 0.12, "condition": {"text": "Patchy rain"}}}]}
+}`
+
+func TestWeatherOn_WeatherAPI(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		q := r.URL.Query()
+		if r.URL.Path != "/history.json" || q.Get("q") != "Denver" {
+			http.Error(w, "{}", http.StatusBadRequest)
+			return
+		}
+		fmt.Fprintf(w, historyFixture, q.Get("dt"))
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.cache = newResponseCache(map[string]time.Duration{"history": time.Hour}, 10)
+
+	now := time.Now()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, -3)
+	line := `[Rex(#99)] Rex says "gravybot weather Denver on 3 days ago"`
+	want := "pose W> Denver, Colorado on " + day.Format("Mon Jan 2 2006") + ": Patchy rain 75/57F, precip 0.12in\n"
+	for i := 0; i < 2; i++ {
+		if got, _ := app.checkLineForRegexps(line); got != want {
+			t.Errorf("got  %q\nwant %q", got, want)
+		}
+	}
+	if hits != 1 {
+		t.Errorf("history fetched %d times, want 1", hits)
+	}
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather Nowhere on yesterday"`)
+	if !strings.Contains(got, "History error: Nowhere not found") {
+		t.Errorf("unknown place: %q", got)
+	}
+}
+
+func TestWeatherOn_Limits(t *testing.T) {
+	app := newTestApp()
+	app.config.historyDays = 30
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	if got, _ := app.weatherOn("#99", "Denver on 2026-10-19", "", "", now); !strings.Contains(got, "hasn't happened yet") {
+		t.Errorf("future: %q", got)
+	}
+	if got, _ := app.weatherOn("#99", "Denver on 2026-01-01", "", "", now); !strings.Contains(got, "only look back 30 days") {
+		t.Errorf("too old: %q", got)
+	}
+	if got, _ := app.weatherOn("#99", "on yesterday", "", "", now); !strings.Contains(got, "no location given") {
+		t.Errorf("no location: %q", got)
+	}
+	if _, ok := app.weatherOn("#99", "Stratford on Avon", "", "", now); ok {
+		t.Error("Stratford on Avon taken as a date")
+	}
+	if got, _ := app.weatherOn("#99", "Denver on feb 29", "", "", now); got != "@pemit #99=Gravybot: feb 29 wasn't a day in 2026.\n" {
+		t.Errorf("no such day: %q", got)
+	}
+}
+
+func TestWeatherOn_Here(t *testing.T) {
+	var asked []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		asked = append(asked, r.URL.Path+" "+q.Get("q"))
+		fmt.Fprintf(w, historyFixture, q.Get("dt"))
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.config.admins = "#1"
+	app.handleLocation("#1", "map #500 Denver")
+	app.checkLineForRegexps("XEPHYR-ROOM: #500 Hangout")
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather here on yesterday"`)
+	if !strings.HasPrefix(got, "pose W> Denver, Colorado on ") || strings.Join(asked, "|") != "/history.json Denver" {
+		t.Errorf("in room: %q %q", got, asked)
+	}
+
+	// Paged, the game is asked where the player is and the day is kept.
+	got, _ = app.checkLineForRegexps(`[Rex(#99)] Rex pages: gravybot weather here on yesterday`)
+	if got != "@pemit me=XEPHYR-WHERE: #99 [loc(#99)]\n" {
+		t.Fatalf("paged: %q", got)
+	}
+	got, _ = app.checkLineForRegexps("XEPHYR-WHERE: #99 #500")
+	if !strings.HasPrefix(got, "pose W> Denver, Colorado on ") || len(asked) != 2 || asked[1] != "/history.json Denver" {
+		t.Errorf("answer: %q %q", got, asked)
+	}
+}
+
+func TestOpenMeteo_HistoryUsesArchiveForOldDays(t *testing.T) {
+	var seen []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/search":
+			fmt.Fprint(w, geocodeFixture)
+		case "/forecast", "/archive":
+			seen = append(seen, r.URL.Path+" "+q.Get("start_date")+" "+q.Get("end_date"))
+			fmt.Fprintf(w, `{"daily": {"time": [%q], "weather_code": [3], "temperature_2m_max": [20],
+  "temperature_2m_min": [10], "precipitation_sum": [0]}}`, q.Get("start_date"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.openMeteoBaseURL = srv.URL
+	app.config.openMeteoGeoURL = srv.URL
+	app.config.openMeteoArchiveURL = srv.URL
+	// weatherapi can't go back a year, so the chain moves on.
+	app.weather = weatherChain{&weatherAPIProvider{app: app}, &openMeteoProvider{app: app}}
+
+	recent := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -10)
+	old := time.Date(2024, 7, 4, 0, 0, 0, 0, time.UTC)
+	got, err := app.sendHistoryRequest("Portland", old, unitsMetric)
+	if want := "Portland, Oregon on Thu Jul 4 2024: Overcast 20/10C, precip 0.0mm\n"; err != nil || got != want {
+		t.Errorf("got  %q, %v\nwant %q", got, err, want)
+	}
+	app.sendHistoryRequest("Portland", recent, "")
+	r := recent.Format("2006-01-02")
+	if want := "/archive 2024-07-04 2024-07-04|/forecast " + r + " " + r; strings.Join(seen, "|") != want {
+		t.Errorf("requests %q, want %q", seen, want)
+	}
+}
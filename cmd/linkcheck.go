@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+)
+
+// linkChecker periodically revisits stored URLs to find the ones that have
+// died. Each distinct URL is checked at most once per maxAge, hosts are
+// checked in parallel up to workers at a time, and requests to the same host
+// are spaced by delay.
+type linkChecker struct {
+	urls     *urlStore
+	client   *http.Client
+	every    time.Duration
+	maxAge   time.Duration
+	delay    time.Duration
+	workers  int
+	batch    int
+	infoLog  *log.Logger
+	errorLog *log.Logger
+}
+
+// linkCheckBatch bounds how many URLs one pass checks.
+const linkCheckBatch = 200
+
+// run checks due links every lc.every, forever.
+func (lc *linkChecker) run() {
+	for {
+		lc.checkDue(time.Now().UTC())
+		time.Sleep(lc.every)
+	}
+}
+
+// checkDue checks the URLs that were never checked or were last checked more
+// than maxAge ago, least recently checked first, and returns how many.
+func (lc *linkChecker) checkDue(now time.Time) int {
+	recs, err := lc.urls.find(func(r urlRecord) bool {
+		return now.Sub(r.Checked) >= lc.maxAge
+	})
+	if err != nil {
+		lc.errorLog.Printf("link check: %s", err)
+		return 0
+	}
+
+	// find is newest first; reverse it so that among equally stale records
+	// the oldest posted goes first, then order by when they were checked.
+	for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+		recs[i], recs[j] = recs[j], recs[i]
+	}
+	sort.SliceStable(recs, func(i, j int) bool {
+		return recs[i].Checked.Before(recs[j].Checked)
+	})
+	seen := map[string]bool{}
+	byHost := map[string][]urlRecord{}
+	n := 0
+	for _, r := range recs {
+		if n >= lc.batch {
+			break
+		}
+		u, err := url.Parse(r.Long)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || seen[r.Norm] {
+			continue
+		}
+		seen[r.Norm] = true
+		byHost[u.Host] = append(byHost[u.Host], r)
+		n++
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	checks := make(map[string]linkCheck, n)
+	workers := lc.workers
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	for _, list := range byHost {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(list []urlRecord) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			for i, r := range list {
+				if i > 0 {
+					time.Sleep(lc.delay)
+				}
+				status := lc.check(r.Long)
+				mu.Lock()
+				checks[r.Norm] = linkCheck{status: status, failed: linkFailed(status)}
+				mu.Unlock()
+			}
+		}(list)
+	}
+	wg.Wait()
+
+	dead, err := lc.urls.recordChecks(checks, now)
+	if err != nil {
+		lc.errorLog.Printf("link check: %s", err)
+	}
+	if n > 0 {
+		lc.infoLog.Printf("link check: %d checked, %d dead", n, dead)
+	}
+	return n
+}
+
+// check returns the status of rawURL, or 0 if it could not be reached. HEAD
+// is tried first; servers that refuse it get a GET.
+func (lc *linkChecker) check(rawURL string) int {
+	status := lc.request("HEAD", rawURL)
+	if status == 0 || status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented || status == http.StatusForbidden {
+		if s := lc.request("GET", rawURL); s != 0 || status == 0 {
+			status = s
+		}
+	}
+	return status
+}
+
+func (lc *linkChecker) request(method, rawURL string) int {
+	req, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return 0
+	}
+	req.Header.Set("User-Agent", "Xephyr/"+version+" (MUSH link checker)")
+	res, err := lc.client.Do(req)
+	if err != nil {
+		return 0
+	}
+	io.Copy(io.Discard, io.LimitReader(res.Body, 64*1024))
+	res.Body.Close()
+	return res.StatusCode
+}
+
+// linkFailed reports whether a check result counts against the link. Pages
+// that refuse bots or rate-limit us are still alive.
+func linkFailed(status int) bool {
+	return status == 0 || status == http.StatusNotFound || status == http.StatusGone || status >= 500
+}
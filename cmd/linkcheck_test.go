@@ -0,0 +1,228 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newTestLinkChecker(app *application, delay time.Duration, workers int) *linkChecker {
+	discard := log.New(io.Discard, "", 0)
+	return &linkChecker{
+		urls:     app.urls,
+		client:   newPublicClient(time.Second, true),
+		maxAge:   24 * time.Hour,
+		delay:    delay,
+		workers:  workers,
+		batch:    linkCheckBatch,
+		infoLog:  discard,
+		errorLog: discard,
+	}
+}
+
+func addURL(t *testing.T, app *application, raw string) {
+	t.Helper()
+	u, _ := url.Parse(raw)
+	if _, err := app.urls.add(urlRecord{Poster: "#99", Name: "Rex", Short: raw, Long: raw, Norm: normalizeURL(u), Posted: time.Now()}); err != nil {
+		t.Fatalf("add: %v", err)
+	}
+}
+
+func TestLinkChecker_Statuses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/nohead":
+			if r.Method == "HEAD" {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+			}
+		case "/private":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	lc := newTestLinkChecker(newTestApp(), 0, 2)
+	tests := map[string]int{
+		"/ok":      200,
+		"/gone":    410,
+		"/nohead":  200,
+		"/private": 401,
+		"/missing": 404,
+	}
+	for path, want := range tests {
+		if got := lc.check(srv.URL + path); got != want {
+			t.Errorf("check(%s) = %d, want %d", path, got, want)
+		}
+	}
+	if got := lc.check("http://127.0.0.1:1/"); got != 0 {
+		t.Errorf("unreachable host gave %d", got)
+	}
+}
+
+func TestLinkChecker_MarksDeadAfterRepeatedFailures(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ok" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	app := newTestApp()
+	addURL(t, app, srv.URL+"/ok")
+	addURL(t, app, srv.URL+"/missing")
+	addURL(t, app, srv.URL+"/missing/") // same normalized URL, checked once
+	addURL(t, app, "ftp://ftp.example.org/pub")
+	lc := newTestLinkChecker(app, 0, 2)
+
+	now := time.Now().UTC()
+	if n := lc.checkDue(now); n != 2 {
+		t.Errorf("first pass checked %d, want 2", n)
+	}
+	if n := lc.checkDue(now.Add(time.Hour)); n != 0 {
+		t.Errorf("recently checked links checked again: %d", n)
+	}
+	if got := app.handleURLs("#1", "dead", now); !strings.Contains(got, "Dead URLs: none.") {
+		t.Errorf("dead after one failure: %q", got)
+	}
+
+	lc.checkDue(now.Add(25 * time.Hour))
+	recs, _ := app.urls.find(urlRecord.dead)
+	if len(recs) != 2 || recs[0].Status != 404 || !strings.Contains(recs[0].Long, "/missing") {
+		t.Errorf("unexpected dead records %+v", recs)
+	}
+
+	got := app.handleURLs("#1", "dead", now)
+	if !strings.Contains(got, "Dead URLs (page 1/1):") || strings.Count(got, "\\[dead\\]") != 2 {
+		t.Errorf("unexpected dead reply %q", got)
+	}
+	if got := app.handleURLs("#1", "by Rex", now); strings.Count(got, "\\[dead\\]") != 2 {
+		t.Errorf("history lacks dead markers: %q", got)
+	}
+}
+
+func TestLinkChecker_RecoveryClearsFailures(t *testing.T) {
+	var up atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	app := newTestApp()
+	addURL(t, app, srv.URL+"/flaky")
+	lc := newTestLinkChecker(app, 0, 1)
+	now := time.Now().UTC()
+	lc.checkDue(now)
+	up.Store(true)
+	lc.checkDue(now.Add(25 * time.Hour))
+
+	recs, _ := app.urls.find(nil)
+	if recs[0].Failures != 0 || recs[0].Status != 200 || recs[0].dead() {
+		t.Errorf("record not recovered: %+v", recs[0])
+	}
+}
+
+func TestLinkChecker_PolitenessAndConcurrency(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var times []time.Time
+	handler := func(record bool) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			if record {
+				times = append(times, time.Now())
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}
+	}
+	a := httptest.NewServer(handler(true))
+	defer a.Close()
+	b := httptest.NewServer(handler(false))
+	defer b.Close()
+	c := httptest.NewServer(handler(false))
+	defer c.Close()
+
+	app := newTestApp()
+	addURL(t, app, a.URL+"/1")
+	addURL(t, app, a.URL+"/2")
+	addURL(t, app, b.URL+"/1")
+	addURL(t, app, c.URL+"/1")
+
+	lc := newTestLinkChecker(app, 50*time.Millisecond, 1)
+	lc.checkDue(time.Now().UTC())
+
+	if maxInFlight != 1 {
+		t.Errorf("max in-flight %d with 1 worker", maxInFlight)
+	}
+	if len(times) != 2 || times[1].Sub(times[0]) < 50*time.Millisecond {
+		t.Errorf("same-host requests not spaced out: %v", times)
+	}
+}
+
+func TestLinkChecker_LeastRecentlyCheckedFirst(t *testing.T) {
+	var mu sync.Mutex
+	var paths []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	app := newTestApp()
+	addURL(t, app, srv.URL+"/a")
+	addURL(t, app, srv.URL+"/b")
+	lc := newTestLinkChecker(app, 0, 1)
+	lc.batch = 1
+	now := time.Now().UTC()
+
+	// /a is checked first as the older post, which leaves /b the stalest.
+	lc.checkDue(now)
+	lc.checkDue(now.Add(25 * time.Hour))
+	lc.checkDue(now.Add(50 * time.Hour))
+	if strings.Join(paths, " ") != "/a /b /a" {
+		t.Errorf("checked in order %v", paths)
+	}
+}
+
+func TestLinkChecker_RepostSharesFailureCount(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	app := newTestApp()
+	addURL(t, app, srv.URL+"/missing")
+	lc := newTestLinkChecker(app, 0, 1)
+	now := time.Now().UTC()
+	lc.checkDue(now)
+
+	// Posted again after one failure, the URL still dies on the next check.
+	addURL(t, app, srv.URL+"/missing")
+	lc.checkDue(now.Add(25 * time.Hour))
+	recs, _ := app.urls.find(nil)
+	for _, r := range recs {
+		if r.Failures != 2 || !r.dead() {
+			t.Errorf("failures not shared: %+v", r)
+		}
+	}
+}
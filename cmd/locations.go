@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// locationsBucket holds server-side location aliases keyed by lower-case name.
+const locationsBucket = "locations"
+
+// locationAlias is a short name for a place, e.g. dino for 39.7,-104.9.
+// Aliases are resolved before any geo lookup, so they work in weather,
+// forecasts, time zones and saved preferences alike.
+type locationAlias struct {
+	Name    string    `json:"name"`
+	Query   string    `json:"query"`
+	AddedBy string    `json:"added_by"`
+	Added   time.Time `json:"added"`
+}
+
+var aliasNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,23}$`)
+
+// reservedAliases are words with their own meaning as a location.
+var reservedAliases = map[string]bool{"here": true}
+
+func (app *application) locationAliases() repo[locationAlias] {
+	return repo[locationAlias]{st: app.store, bucket: locationsBucket}
+}
+
+// resolveLocation returns what an alias stands for, or loc unchanged.
+func (app *application) resolveLocation(loc string) string {
+	loc = strings.TrimSpace(loc)
+	a, ok, err := app.locationAliases().get(strings.ToLower(loc))
+	if err != nil {
+		app.errorLog.Printf("location alias %s: %s", loc, err)
+	}
+	if ok {
+		return a.Query
+	}
+	return loc
+}
+
+// handleLocation implements "gravybot location add|remove|list". Anyone may
+// add a new alias; only its creator or an admin may replace or remove it.
+// The room mapping subcommands are handled by handleRoomLocation.
+func (app *application) handleLocation(userID, args string) string {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		fields = []string{"list"}
+	}
+	switch strings.ToLower(fields[0]) {
+	case "list":
+		return app.listLocations(userID)
+	case "add", "set":
+		if len(fields) < 3 {
+			break
+		}
+		return app.addLocation(userID, fields[1], strings.Join(fields[2:], " "))
+	case "remove", "rm", "del", "delete":
+		if len(fields) != 2 {
+			break
+		}
+		return app.removeLocation(userID, fields[1])
+	case "map", "unmap", "rooms":
+		return app.handleRoomLocation(userID, strings.ToLower(fields[0]), fields[1:])
+	}
+	return "@pemit " + userID + "=Gravybot: try gravybot location add <name> <place>|remove <name>|list\n"
+}
+
+func (app *application) addLocation(userID, name, query string) string {
+	if !aliasNameRe.MatchString(name) || reservedAliases[strings.ToLower(name)] {
+		return "@pemit " + userID + "=Gravybot: " + mushEscape(name) + " can't be a location name; use letters, digits, - and _.\n"
+	}
+	r := app.locationAliases()
+	key := strings.ToLower(name)
+	old, ok, err := r.get(key)
+	if err == nil && ok && old.AddedBy != userID && !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: " + mushEscape(old.Name) + " belongs to someone else.\n"
+	}
+	if _, ok, _ := r.get(strings.ToLower(query)); ok {
+		return "@pemit " + userID + "=Gravybot: a location can't point at another alias.\n"
+	}
+	a := locationAlias{Name: name, Query: parseLatLon(strings.TrimSpace(query)), AddedBy: userID, Added: time.Now().UTC()}
+	if err == nil {
+		err = r.put(key, a)
+	}
+	if err != nil {
+		app.errorLog.Printf("location alias %s: %s", name, err)
+		return "@pemit " + userID + "=Gravybot: couldn't save that location.\n"
+	}
+	return fmt.Sprintf("@pemit %s=Gravybot: %s now means %s.\n", userID, mushEscape(name), mushEscape(a.Query))
+}
+
+func (app *application) removeLocation(userID, name string) string {
+	r := app.locationAliases()
+	key := strings.ToLower(name)
+	a, ok, err := r.get(key)
+	if err == nil && !ok {
+		return "@pemit " + userID + "=Gravybot: no location named " + mushEscape(name) + ".\n"
+	}
+	if err == nil && a.AddedBy != userID && !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: " + mushEscape(a.Name) + " belongs to someone else.\n"
+	}
+	if err == nil {
+		err = r.delete(key)
+	}
+	if err != nil {
+		app.errorLog.Printf("location alias %s: %s", name, err)
+		return "@pemit " + userID + "=Gravybot: couldn't remove that location.\n"
+	}
+	return "@pemit " + userID + "=Gravybot: removed location " + mushEscape(a.Name) + ".\n"
+}
+
+func (app *application) listLocations(userID string) string {
+	all, err := app.locationAliases().all()
+	if err != nil {
+		app.errorLog.Printf("location aliases: %s", err)
+		return "@pemit " + userID + "=Gravybot: couldn't read the locations.\n"
+	}
+	if len(all) == 0 {
+		return "@pemit " + userID + "=Gravybot: no locations yet. Try: gravybot location add <name> <place>\n"
+	}
+	keys := make([]string, 0, len(all))
+	for k := range all {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	entries := make([]string, 0, len(keys))
+	for _, k := range keys {
+		entries = append(entries, all[k].Name+" ("+all[k].Query+")")
+	}
+	return "@pemit " + userID + "=Gravybot: locations: " + mushEscape(strings.Join(entries, ", ")) + "\n"
+}
+
+// zoneForPlace finds the time zone of a place by asking the weather
+// providers about it.
+func (app *application) zoneForPlace(place string) (*time.Location, error) {
+	r, err := app.currentWeather(parseLatLon(app.resolveLocation(place)))
+	if err != nil {
+		return nil, err
+	}
+	if r.Place.TZ == "" {
+		return nil, fmt.Errorf("no time zone for %s", place)
+	}
+	return time.LoadLocation(r.Place.TZ)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleLocation_AddListRemove(t *testing.T) {
+	app := newTestApp()
+	app.config.admins = "#1"
+
+	if got := app.handleLocation("#42", "add dino 39.7 -104.9"); got != "@pemit #42=Gravybot: dino now means 39.7,-104.9.\n" {
+		t.Errorf("add: %q", got)
+	}
+	app.handleLocation("#43", "add Home Boston MA")
+	want := "@pemit #42=Gravybot: locations: dino (39.7,-104.9), Home (Boston MA)\n"
+	if got := app.handleLocation("#42", ""); got != want {
+		t.Errorf("list: %q", got)
+	}
+	if got := app.resolveLocation(" HOME "); got != "Boston MA" {
+		t.Errorf("resolve: %q", got)
+	}
+	if got := app.resolveLocation("Paris"); got != "Paris" {
+		t.Errorf("non-alias changed: %q", got)
+	}
+
+	if got := app.handleLocation("#43", "add dino Denver"); !strings.Contains(got, "belongs to someone else") {
+		t.Errorf("replaced someone else's alias: %q", got)
+	}
+	if got := app.handleLocation("#43", "remove dino"); !strings.Contains(got, "belongs to someone else") {
+		t.Errorf("removed someone else's alias: %q", got)
+	}
+	if got := app.handleLocation("#1", "remove dino"); got != "@pemit #1=Gravybot: removed location dino.\n" {
+		t.Errorf("admin remove: %q", got)
+	}
+	if got := app.handleLocation("#1", "remove dino"); !strings.Contains(got, "no location named dino") {
+		t.Errorf("remove missing: %q", got)
+	}
+}
+
+func TestHandleLocation_Rejects(t *testing.T) {
+	app := newTestApp()
+	app.handleLocation("#42", "add home Boston")
+	tests := []struct{ args, want string }{
+		{"add here Boston", "can't be a location name"},
+		{"add a[b] Boston", "can't be a location name"},
+		{"add work home", "can't point at another alias"},
+		{"add work", "try gravybot location"},
+		{"frob", "try gravybot location"},
+	}
+	for _, tt := range tests {
+		if got := app.handleLocation("#42", tt.args); !strings.Contains(got, tt.want) {
+			t.Errorf("%q: got %q", tt.args, got)
+		}
+	}
+}
+
+// newZoneServer answers current.json with a location carrying tz_id,
+// recording the queries it saw.
+func newZoneServer(t *testing.T, queries *[]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query().Get("q")
+		*queries = append(*queries, r.URL.Path+" "+q)
+		if q == "nowhere" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"location": {"name": "Denver", "region": "Colorado", "country": "United States of America",
+  "tz_id": "America/Denver"}, "current": {"temp_f": 70, "condition": {"text": "Sunny"}},
+  "forecast": {"forecastday": []}}`)
+	}))
+}
+
+func TestAliasesResolvedForGeoCommands(t *testing.T) {
+	var queries []string
+	srv := newZoneServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.handleLocation("#42", "add dino 39.7:-104.9")
+
+	app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather dino"`)
+	app.handleForecast("#99", "dino")
+	app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun dino"`)
+	app.prefs.set("#99", "location", "dino")
+	app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather"`)
+	want := "/current.json 39.7,-104.9|/forecast.json 39.7,-104.9|/astronomy.json 39.7,-104.9|/current.json 39.7,-104.9"
+	if strings.Join(queries, "|") != want {
+		t.Errorf("queries\n%q\nwant\n%q", strings.Join(queries, "|"), want)
+	}
+}
+
+func TestLocalTime_Place(t *testing.T) {
+	var queries []string
+	srv := newZoneServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.handleLocation("#42", "add dino Denver")
+	now := time.Date(2026, 6, 3, 18, 30, 0, 0, time.UTC)
+
+	if got := app.localTime("#1", "dino", now); got != "Wed Jun 3 12:30 MDT (America/Denver)\n" {
+		t.Errorf("alias: %q", got)
+	}
+	if got := app.localTime("#1", "nowhere", now); got != "Time error: unknown time zone or place nowhere\n" {
+		t.Errorf("unknown: %q", got)
+	}
+	if got := app.localTime("#1", "Europe/Paris", now); !strings.HasSuffix(got, "(Europe/Paris)\n") || len(queries) != 2 {
+		t.Errorf("zone name went to the weather API: %q %q", got, queries)
+	}
+}
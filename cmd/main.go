@@ -13,31 +13,91 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"os/signal"
 	"regexp"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/reiver/go-telnet"
 )
 
 type config struct {
-	srvAddr       string
-	yirpAPIAddr   string
-	yirpapikey    string
-	username      string
-	password      string
-	weatherapikey    string
-	finnhubapikey    string
-	coingeckoapikey  string
-	coingeckoBaseURL string
+	srvAddr             string
+	yirpAPIAddr         string
+	yirpapikey          string
+	username            string
+	password            string
+	weatherapikey       string
+	finnhubapikey       string
+	coingeckoapikey     string
+	coingeckoBaseURL    string
+	weatherBaseURL      string
+	weatherProviders    string
+	cacheTTLs           string
+	cacheSize           int
+	persistCache        bool
+	openMeteoBaseURL    string
+	openMeteoGeoURL     string
+	openMeteoArchiveURL string
+	openMeteoAirURL     string
+	historyDays         int
+	alertPoll           time.Duration
+	units               string
+	screenWidth         int
+	botName             string
+	addressing          string
+	dataDir             string
+	urlDupWindow        time.Duration
+	fetchTitles         bool
+	titleTimeout        time.Duration
+	titleMaxBytes       int64
+	titleDeny           string
+	shorteners          string
+	restShortenerURL    string
+	restShortenerField  string
+	restShortenerResult string
+	restShortenerAuth   string
+	localBaseURL        string
+	recordUnshortened   bool
+	httpAddr            string
+	urlSchemes          string
+	admins              string
+	urlMinLength        int
+	shortDomains        string
+	urlAllow            string
+	urlDeny             string
+	blocklists          string
+	blockAction         string
+	linkCheckEvery      time.Duration
+	linkCheckAge        time.Duration
+	linkCheckDelay      time.Duration
+	linkCheckWorkers    int
+	urlDB               string
+	urlWindow           int
 }
 
 type application struct {
-	config   config
-	infoLog  *log.Logger
-	errorLog *log.Logger
-	version  string
+	config    config
+	infoLog   *log.Logger
+	errorLog  *log.Logger
+	version   string
+	store     *store
+	prefs     *prefStore
+	urls      *urlStore
+	links     *linkStore
+	extractor *urlExtractor
+	policy    *urlPolicy
+	blocklist *blocklist
+	titles    *titleFetcher
+	state     *botState
+
+	shorteners shortenerChain
+	weather    weatherChain
+	cache      *responseCache
+	outbox     chan string  // commands from background jobs, sent by the connection
+	urlPosts   chan urlPost // lines with URLs, captured off the read loop
 }
 
 var version string = "1.0"
@@ -47,9 +107,66 @@ func main() {
 
 	flag.StringVar(&cfg.srvAddr, "s", "dino.surly.org:6250", "Server:port address")
 	flag.StringVar(&cfg.yirpAPIAddr, "yirpaddr", "https://api.yirp.org/v1/shorten", "Yirp API Address")
+	flag.StringVar(&cfg.botName, "name", "gravybot", "Name players use to address the bot")
+	flag.StringVar(&cfg.addressing, "addressing", "mention", "Command addressing rule: say (quoted says only) or mention (bot name followed by a command word anywhere)")
+	flag.StringVar(&cfg.dataDir, "datadir", "data", "Directory for the bot's persistent data store")
+	flag.DurationVar(&cfg.urlDupWindow, "urldupwindow", 7*24*time.Hour, "Reuse the short link for URLs reposted within this window (0 disables)")
+	flag.BoolVar(&cfg.fetchTitles, "titles", true, "Fetch and announce the titles of posted pages")
+	flag.DurationVar(&cfg.titleTimeout, "titletimeout", 5*time.Second, "Time limit for fetching a page title")
+	flag.Int64Var(&cfg.titleMaxBytes, "titlemaxbytes", 512*1024, "Most bytes of a page read when looking for its title")
+	flag.StringVar(&cfg.titleDeny, "titledeny", "", "Comma-separated domains whose pages are never fetched for titles")
+
+	flag.StringVar(&cfg.shorteners, "shorteners", "yirp", "Comma-separated URL shorteners to try in order: yirp, rest, local")
+	flag.StringVar(&cfg.restShortenerURL, "restshortener", "", "Endpoint of a JSON REST shortener used by the rest backend")
+	flag.StringVar(&cfg.restShortenerField, "restshortenerfield", "url", "Request field holding the long URL for the rest backend")
+	flag.StringVar(&cfg.restShortenerResult, "restshortenerresult", "short_url", "Response field (dotted path) holding the short URL for the rest backend")
+	flag.StringVar(&cfg.localBaseURL, "localbase", "", "Public base URL of short links issued by the local backend, e.g. https://go.example.com")
+	flag.StringVar(&cfg.urlSchemes, "urlschemes", strings.Join(defaultURLSchemes, ","), "Comma-separated URL schemes captured from chat (www. links are always captured)")
+	flag.StringVar(&cfg.admins, "admins", "", "Comma-separated dbrefs of players allowed to change bot-wide settings")
+	flag.IntVar(&cfg.urlMinLength, "urlminlen", 25, "URLs shorter than this are recorded as posted instead of shortened")
+	flag.StringVar(&cfg.shortDomains, "shortdomains", strings.Join(defaultShortDomains, ","), "Comma-separated shortener domains whose links are recorded as posted")
+	flag.StringVar(&cfg.urlAllow, "urlallow", "", "Comma-separated domain globs; when set, only matching URLs are captured")
+	flag.StringVar(&cfg.urlDeny, "urldeny", "", "Comma-separated domain globs whose URLs are never captured")
+	flag.StringVar(&cfg.blocklists, "blocklists", "", "Comma-separated hostname or hosts-format files of blocked domains")
+	flag.StringVar(&cfg.blockAction, "blockaction", "flag", "What to do with blocked URLs: flag (warn instead of announcing) or suppress (say nothing)")
+	flag.DurationVar(&cfg.linkCheckEvery, "linkcheck", 6*time.Hour, "How often to look for dead links in URL history (0 disables)")
+	flag.DurationVar(&cfg.linkCheckAge, "linkcheckage", 7*24*time.Hour, "How long a link check result is trusted before checking again")
+	flag.DurationVar(&cfg.linkCheckDelay, "linkcheckdelay", 2*time.Second, "Pause between link checks to the same host")
+	flag.IntVar(&cfg.linkCheckWorkers, "linkcheckworkers", 4, "Hosts checked for dead links at the same time")
+	flag.StringVar(&cfg.urlDB, "urldb", "#1818", "Object holding the gurl URL_* attributes, kept in sync from URL history (empty leaves it to add_url)")
+	flag.IntVar(&cfg.urlWindow, "urlwindow", 50, "How many recent URLs are kept in the URL_* attributes")
+	flag.StringVar(&cfg.weatherProviders, "weatherproviders", "weatherapi,openmeteo", "Weather providers to try in order: weatherapi, openmeteo")
+	flag.StringVar(&cfg.cacheTTLs, "cachettl", defaultCacheTTLs, "How long API responses are cached, as provider=duration pairs (weather, history, quotes, translations)")
+	flag.IntVar(&cfg.cacheSize, "cachesize", 1000, "Most API responses kept in the cache (0 disables the cache)")
+	flag.BoolVar(&cfg.persistCache, "persistcache", false, "Save the API response cache in the data directory across restarts")
+	flag.IntVar(&cfg.historyDays, "historydays", defaultHistoryDays, "How many days back \"weather <location> on <date>\" may look")
+	flag.DurationVar(&cfg.alertPoll, "alertpoll", 15*time.Minute, "How often subscribers' locations are checked for severe weather alerts (0 disables)")
+	flag.IntVar(&cfg.screenWidth, "screenwidth", defaultScreenWidth, "Width the weather comparison table is fitted to")
+	flag.StringVar(&cfg.units, "units", "", "Default weather units: metric, imperial or both (empty follows the location's country)")
+	flag.StringVar(&cfg.httpAddr, "http", "", "Listen address of the built-in short-link server, e.g. :8080 (empty disables)")
+	flag.BoolVar(&cfg.recordUnshortened, "recordunshortened", false, "Record and announce the long URL when every shortener fails")
 
 	flag.Parse()
 
+	if u, err := normalizePref("units", cfg.units); err != nil {
+		fmt.Fprintln(os.Stderr, "-units:", err)
+		os.Exit(2)
+	} else {
+		cfg.units = u
+	}
+	if cfg.blockAction != "flag" && cfg.blockAction != "suppress" {
+		fmt.Fprintf(os.Stderr, "-blockaction: must be flag or suppress, not '%s'\n", cfg.blockAction)
+		os.Exit(2)
+	}
+
+	// With our own link server running and a public address for it, shorten
+	// locally unless told otherwise.
+	shortenersSet := false
+	flag.Visit(func(f *flag.Flag) { shortenersSet = shortenersSet || f.Name == "shorteners" })
+	if cfg.httpAddr != "" && cfg.localBaseURL != "" && !shortenersSet {
+		cfg.shorteners = "local,yirp"
+	}
+
 	cfg.username = os.Getenv("BOT_USERNAME")
 	cfg.password = os.Getenv("BOT_PASSWORD")
 	cfg.yirpapikey = os.Getenv("YIRP_APIKEY")
@@ -57,20 +174,141 @@ func main() {
 	cfg.finnhubapikey = os.Getenv("FINNHUB_APIKEY")
 	cfg.coingeckoapikey = os.Getenv("COINGECKO_APIKEY")
 	cfg.coingeckoBaseURL = "https://api.coingecko.com/api/v3"
+	cfg.weatherBaseURL = "https://api.weatherapi.com/v1"
+	cfg.openMeteoBaseURL = "https://api.open-meteo.com/v1"
+	cfg.openMeteoGeoURL = "https://geocoding-api.open-meteo.com/v1"
+	cfg.openMeteoArchiveURL = "https://archive-api.open-meteo.com/v1"
+	cfg.openMeteoAirURL = "https://air-quality-api.open-meteo.com/v1"
+	cfg.restShortenerAuth = os.Getenv("REST_SHORTENER_AUTH")
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	if err := os.MkdirAll(cfg.dataDir, 0o755); err != nil {
+		errorLog.Fatal(err)
+	}
+	st, err := openStore(cfg.dataDir)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
 	app := &application{
-		config:   cfg,
-		infoLog:  infoLog,
-		errorLog: errorLog,
-		version:  version,
+		config:    cfg,
+		infoLog:   infoLog,
+		errorLog:  errorLog,
+		version:   version,
+		store:     st,
+		prefs:     newPrefStore(st),
+		urls:      newURLStore(st),
+		links:     newLinkStore(st),
+		extractor: newURLExtractor(strings.Split(cfg.urlSchemes, ",")),
+		policy:    newURLPolicy(cfg.urlMinLength, strings.Split(cfg.shortDomains, ","), strings.Split(cfg.urlAllow, ","), strings.Split(cfg.urlDeny, ",")),
+		state:     &botState{},
+	}
+	if app.shorteners, err = app.buildShorteners(); err != nil {
+		errorLog.Fatal(err)
+	}
+	if app.weather, err = app.buildWeatherProviders(); err != nil {
+		errorLog.Fatal(err)
+	}
+	if cfg.cacheSize > 0 {
+		ttls, err := parseCacheTTLs(cfg.cacheTTLs)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		app.cache = newResponseCache(ttls, cfg.cacheSize)
+		if cfg.persistCache {
+			n, err := app.cache.load(cfg.dataDir)
+			if err != nil {
+				errorLog.Printf("cache: %s", err)
+			}
+			infoLog.Printf("loaded %d cached responses", n)
+			go func() {
+				for range time.Tick(time.Minute) {
+					if err := app.cache.save(cfg.dataDir); err != nil {
+						errorLog.Printf("cache: %s", err)
+					}
+				}
+			}()
+		}
+	}
+	if base, err := url.Parse(cfg.localBaseURL); err == nil && base.Hostname() != "" {
+		// Our own short links are already as short as they get.
+		app.policy.shortDomains = append(app.policy.shortDomains, strings.ToLower(base.Hostname()))
+	}
+	if cfg.blocklists != "" {
+		app.blocklist = newBlocklist(strings.Split(cfg.blocklists, ","))
+		n, err := app.blocklist.reload()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("loaded %d blocked hosts", n)
+
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				if n, err := app.blocklist.reload(); err != nil {
+					errorLog.Printf("blocklist reload: %s", err)
+				} else {
+					infoLog.Printf("reloaded %d blocked hosts", n)
+				}
+			}
+		}()
+	}
+	if cfg.fetchTitles {
+		app.titles = newTitleFetcher(cfg.titleTimeout, cfg.titleMaxBytes, strings.Split(cfg.titleDeny, ","), false)
 	}
 
 	fmt.Println("Xepher MUSH Bot version:", app.version)
 
-	err := telnet.DialToAndCall(app.config.srvAddr, caller{*app})
+	if cfg.linkCheckEvery > 0 {
+		lc := &linkChecker{
+			urls:     app.urls,
+			client:   newPublicClient(10*time.Second, false),
+			every:    cfg.linkCheckEvery,
+			maxAge:   cfg.linkCheckAge,
+			delay:    cfg.linkCheckDelay,
+			workers:  cfg.linkCheckWorkers,
+			batch:    linkCheckBatch,
+			infoLog:  infoLog,
+			errorLog: errorLog,
+		}
+		go lc.run()
+	}
+
+	app.outbox = make(chan string, outboxSize)
+	app.urlPosts = make(chan urlPost, urlPostsSize)
+	go app.captureURLs()
+
+	if app.alertsEnabled() {
+		ap := &alertPoller{app: app, every: cfg.alertPoll, send: app.queue}
+		go ap.run()
+	}
+
+	if cfg.httpAddr != "" {
+		srv := &http.Server{
+			Addr:              cfg.httpAddr,
+			Handler:           app.routes(),
+			ReadHeaderTimeout: 5 * time.Second,
+		}
+		go func() {
+			infoLog.Printf("link server listening on %s", cfg.httpAddr)
+			errorLog.Fatal(srv.ListenAndServe())
+		}()
+		go func() {
+			for range time.Tick(clickFlushEvery) {
+				if err := app.links.flush(); err != nil {
+					errorLog.Printf("link clicks: %s", err)
+				}
+			}
+		}()
+	}
+
+	err = telnet.DialToAndCall(app.config.srvAddr, caller{*app})
+	if ferr := app.links.flush(); ferr != nil {
+		errorLog.Printf("link clicks: %s", ferr)
+	}
 
 	if err != nil {
 		log.Fatal(err)
@@ -97,7 +335,35 @@ type YirpResponse struct {
 	CreatedAt string `json:"created_at"`
 }
 
+// isAdmin reports whether dbref is listed in -admins.
+func (app *application) isAdmin(dbref string) bool {
+	for _, a := range strings.Split(app.config.admins, ",") {
+		if strings.TrimSpace(a) == dbref && dbref != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// mushEscape backslash-escapes the characters the MUSH would otherwise
+// evaluate, so text from players or web pages is echoed back literally.
+func mushEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\', '%', '[', ']', '{', '}':
+			b.WriteRune('\\')
+		case '\n', '\r':
+			r = ' '
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (app *application) botSend(w telnet.Writer, data string) {
+	app.state.send.Lock()
+	defer app.state.send.Unlock()
 	app.infoLog.Println(data)
 	_, err := w.Write([]byte(data))
 	if err != nil {
@@ -112,6 +378,7 @@ type WeatherAPIResponse struct {
 		Country string  `json:"country"`
 		Lat     float64 `json:"lat"`
 		Lon     float64 `json:"lon"`
+		Tz_id   string  `json:"tz_id"`
 	} `json:"location"`
 
 	Current struct {
@@ -121,10 +388,17 @@ type WeatherAPIResponse struct {
 		Condition    struct {
 			Text string `json:"text"`
 		} `json:"condition"`
-		Wind_mph float64 `json:"wind_mph"`
-		Wind_kph float64 `json:"wind_kph"`
-		Wind_dir string  `json:"wind_dir"`
-		Humidity float64 `json:"humidity"`
+		Wind_mph    float64 `json:"wind_mph"`
+		Wind_kph    float64 `json:"wind_kph"`
+		Wind_dir    string  `json:"wind_dir"`
+		Humidity    float64 `json:"humidity"`
+		Feelslike_c float64 `json:"feelslike_c"`
+		Feelslike_f float64 `json:"feelslike_f"`
+		Gust_kph    float64 `json:"gust_kph"`
+		Gust_mph    float64 `json:"gust_mph"`
+		Precip_mm   float64 `json:"precip_mm"`
+		Precip_in   float64 `json:"precip_in"`
+		Uv          float64 `json:"uv"`
 	} `json:"current"`
 }
 
@@ -254,51 +528,15 @@ func parseLatLon(s string) string {
 	return s
 }
 
-func (app *application) sendWeatherRequest(query string) (string, error) {
-	res, err := http.Get("https://api.weatherapi.com/v1/current.json?key=" + app.config.weatherapikey + "&q=" + query + "&aqi=no")
-
+// sendWeatherRequest looks up current conditions for loc. units is
+// "metric", "imperial" or "both"; when empty the units follow the location's
+// country. verbose adds feels-like, gusts, precipitation and UV index.
+func (app *application) sendWeatherRequest(loc, units string, verbose bool) (string, error) {
+	r, err := app.currentWeather(loc)
 	if err != nil {
-		app.errorLog.Printf("weather request failed: %s", err)
-		return "", err
+		return app.weatherFailure("Weather", loc, err)
 	}
-	defer res.Body.Close()
-
-	if res.StatusCode == 400 {
-		result := "Weather error: " + query + " not found. Try using a city state or city country pair.\n"
-		fmt.Println(result)
-		return result, nil
-	}
-
-	if res.StatusCode > 299 {
-		result := "Weather error: API returned code: " + strconv.Itoa(res.StatusCode) + "\n"
-		fmt.Println(result)
-		return result, nil
-	}
-
-	body, err := io.ReadAll(res.Body)
-	if err != nil {
-		app.errorLog.Printf("Weather request failed ReadAll: %s", err)
-		return "", err
-	}
-
-	var weatherResponse WeatherAPIResponse
-	err = json.Unmarshal(body, &weatherResponse)
-	if err != nil {
-		fmt.Println("error:", err)
-	}
-
-	var locationRegion string
-	var result string
-
-	if strings.HasPrefix(weatherResponse.Location.Country, "United States of America") || strings.HasPrefix(weatherResponse.Location.Country, "USA") {
-		locationRegion = weatherResponse.Location.Region
-		result = fmt.Sprintf("%v, %v: %v %.1fF %.1f%%%% %.1fmph %v\n", weatherResponse.Location.Name, locationRegion, weatherResponse.Current.Condition.Text, weatherResponse.Current.Temp_f, weatherResponse.Current.Humidity, weatherResponse.Current.Wind_mph, weatherResponse.Current.Wind_dir)
-	} else {
-		locationRegion = weatherResponse.Location.Country
-		result = fmt.Sprintf("%v, %v: %v %.1fC %.1f%%%% %.1fkph %v\n", weatherResponse.Location.Name, locationRegion, weatherResponse.Current.Condition.Text, weatherResponse.Current.Temp_c, weatherResponse.Current.Humidity, weatherResponse.Current.Wind_kph, weatherResponse.Current.Wind_dir)
-	}
-
-	return result, nil
+	return formatCurrent(r, units, verbose), nil
 }
 
 func (app *application) getStockQuote(query string) (string, error) {
@@ -1556,21 +1794,89 @@ func generateHoroscope(userID int, date time.Time) string {
 	return fmt.Sprintf("%s %s %s Lucky number for today: %s.", opener, prediction, closer, luckyNum)
 }
 
+// commandWords are the subcommands that count as addressing the bot when its
+// name turns up in a pose, semipose or @emit rather than at the start of a say.
+var commandWords = []string{"weather", "forecast", "aqi", "alerts", "translate", "stock", "horoscope", "urls", "cache"}
+
+// genericCommandWords are subcommands that are also everyday words ("I have
+// no time, gravybot time to go"), so outside a say that leads with the bot
+// name they only count when the name is set off with a comma or colon:
+// "Dino asks gravybot, time Tokyo".
+var genericCommandWords = []string{"set", "prefs", "time", "sun", "location", "locations"}
+
+var (
+	nospoofRe = regexp.MustCompile(`^\[(.*)\((#\d+)\)\] (.*)$`)
+	saysRe    = regexp.MustCompile(`^.+ says "(.*)"$`)
+)
+
+// commandText extracts the bot command from the text following a nospoof tag.
+// Says are used as-is. Under the "mention" addressing rule, poses, semiposes
+// and emits (and says that don't lead with a command) are also accepted when
+// they contain the bot name followed by a command word, or by a comma or colon
+// and one of the generic command words. The bot name is
+// rewritten to "gravybot" so the dispatch regexps only need one spelling.
+func (app *application) commandText(rest string) string {
+	name := app.config.botName
+	if name == "" {
+		name = "gravybot"
+	}
+
+	said := ""
+	if s := saysRe.FindStringSubmatch(rest); s != nil {
+		said = s[1]
+		if len(said) >= len(name) && strings.EqualFold(said[:len(name)], name) {
+			rest := said[len(name):]
+			if strings.HasPrefix(rest, ":") {
+				rest = "," + rest[1:]
+			}
+			return "gravybot" + rest
+		}
+		if app.config.addressing == "say" {
+			return said
+		}
+		rest = said
+	} else if app.config.addressing == "say" {
+		return ""
+	}
+
+	re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(name) + `(?:[,:]?\s+((?:` + strings.Join(commandWords, "|") +
+		`)\b.*)|[,:]\s*((?:` + strings.Join(genericCommandWords, "|") + `)\b.*))$`)
+	if m := re.FindStringSubmatch(rest); m != nil {
+		return "gravybot " + strings.TrimRight(m[1]+m[2], ".!?\"' ")
+	}
+	return said
+}
+
 func (app *application) checkLineForRegexps(line string) (string, error) {
 	var userID string
 
-	re := regexp.MustCompile(`^\[.*\((#\d+)\)\]`)
-	userIDMatch := re.FindSubmatch([]byte(line))
-	if len(userIDMatch) < 2 {
+	if m := roomRe.FindStringSubmatch(line); m != nil {
+		app.state.setRoom(m[1], m[2])
 		return "", nil
 	}
-	userID = string(userIDMatch[1])
+	if m := whereRe.FindStringSubmatch(line); m != nil {
+		return app.answerWhere(m[1], m[2], time.Now()), nil
+	}
+	if reply, ok := app.answerURLRing(line, time.Now()); ok {
+		return reply, nil
+	}
 
-	re = regexp.MustCompile(`(http\:|https\:|ftp\:|ftps\:|telnet\:|telnets\:|ssh\:|www\.)[^ \"]+`)
+	userIDMatch := nospoofRe.FindStringSubmatch(line)
+	if len(userIDMatch) < 4 {
+		return "", nil
+	}
+	userID = userIDMatch[2]
+	text := app.commandText(userIDMatch[3])
 
-	urls := re.FindAll([]byte(line), -1)
-	if len(urls) > 0 {
-		return app.processUrls(userID, urls)
+	// History searches may quote URLs, which must not be captured as posts.
+	re := regexp.MustCompile(`(?i)^Gravybot\,? urls\s*(.*)$`)
+	if s := re.FindStringSubmatch(text); s != nil {
+		return app.handleURLs(userID, s[1], time.Now()), nil
+	}
+
+	if urls := app.extractor.extract(userIDMatch[3]); len(urls) > 0 {
+		app.postURLs(urlPost{userID, userIDMatch[1], app.whereFrom(userIDMatch[3]), urls})
+		return "", nil
 	}
 
 	re = regexp.MustCompile(`\[.*\(#\d+\)\] .+ pages: hangout$`)
@@ -1586,8 +1892,45 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 		return command, nil
 	}
 
-	re = regexp.MustCompile(`(?i)\[.*\(#\d+\)\] .+ says "Gravybot\,? translate (\S+) (\S+) (.*)"$`)
-	s := re.FindSubmatch([]byte(line))
+	if text == "" {
+		return "", nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? set (\S+)\s*(.*)$`)
+	if s := re.FindStringSubmatch(text); s != nil {
+		return app.handleSet(userID, s[1], s[2]), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? prefs$`)
+	if re.MatchString(text) {
+		return "@pemit " + userID + "=Gravybot: your settings: " + mushEscape(app.prefs.get(userID).String()) + "\n", nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? locations?(?:\s+(.*))?$`)
+	if s := re.FindStringSubmatch(text); s != nil {
+		return app.handleLocation(userID, s[1]), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? alerts(?:\s+(.*))?$`)
+	if s := re.FindStringSubmatch(text); s != nil {
+		return app.handleAlerts(userID, s[1]), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? time\s*(.*)$`)
+	if s := re.FindStringSubmatch(text); s != nil {
+		return "pose C> " + app.localTime(userID, strings.TrimSpace(s[1]), time.Now()), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? translate (\S+) (\S+) (.*)$`)
+	s := re.FindSubmatch([]byte(text))
+	if lang := app.prefs.get(userID).Lang; lang != "" {
+		// With a saved target language, "translate <text>" means auto -> lang
+		// unless the first two words are themselves language codes.
+		re = regexp.MustCompile(`(?i)^Gravybot\,? translate (.+)$`)
+		if t := re.FindSubmatch([]byte(text)); t != nil && (s == nil || !langCodeRe.Match(s[1]) || !langCodeRe.Match(s[2])) {
+			s = [][]byte{t[0], []byte("auto"), []byte(lang), t[1]}
+		}
+	}
 	if s != nil {
 		if len(s) != 4 {
 			fmt.Println("GRAVYTRANSLATE wrong len")
@@ -1597,7 +1940,10 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 			targetLang := string(s[2])
 			textToTranslate := string(s[3])
 
-			translatedText, err := app.translateText(sourceLang, targetLang, textToTranslate)
+			key := sourceLang + "|" + targetLang + "|" + textToTranslate
+			translatedText, err := cachedReply(app.cache, "translations", key, "Translation error", func() (string, error) {
+				return app.translateText(sourceLang, targetLang, textToTranslate)
+			})
 			if err != nil {
 				fmt.Println("GRAVYTRANSLATE request fail")
 				fmt.Println(err)
@@ -1610,28 +1956,59 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 		}
 	}
 
-	re = regexp.MustCompile(`(?i)\[.*\(#\d+\)\] .+ says "Gravybot\,? weather (.+)"$`)
-	s = re.FindSubmatch([]byte(line))
+	re = regexp.MustCompile(`(?i)^Gravybot\,? cache(?:\s+(.*))?$`)
+	if f := re.FindStringSubmatch(text); f != nil {
+		return app.handleCache(userID, f[1]), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? forecast\s*(.*)$`)
+	if f := re.FindStringSubmatch(text); f != nil {
+		return app.handleForecast(userID, f[1]), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? aqi(?:\s+(.*))?$`)
+	if f := re.FindStringSubmatch(text); f != nil {
+		return app.forLocations(userID, f[1], "A", "aqi", app.sendAQIRequest), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? sun(?:\s+(.*))?$`)
+	if f := re.FindStringSubmatch(text); f != nil {
+		return app.forLocations(userID, f[1], "S", "astronomy", app.sendSunRequest), nil
+	}
+
+	re = regexp.MustCompile(`(?i)^Gravybot\,? weather\s*(.*)$`)
+	s = re.FindSubmatch([]byte(text))
 
 	if s != nil {
 		if len(s) < 2 {
 			fmt.Println("GRAVYWEATHER wrong len")
 			return "", nil
 		} else {
-			locations := strings.Split(string(s[1]), ",")
-			if len(locations) > 5 {
-				locations = locations[:5]
+			where, verbose := stripFlag(string(s[1]), "-v", "--verbose")
+			where, table := stripFlag(where, "-t", "--table", "--compare")
+			where, byTemp := stripFlag(where, "-s", "--sort")
+			where, requested := parseUnitFlags(where)
+			units := app.chooseUnits(requested, userID)
+			room := ""
+			if sameRoom(userIDMatch[1], userIDMatch[3]) {
+				room = app.state.currentRoom()
+			}
+			if reply, ok := app.weatherOn(userID, where, room, units, time.Now()); ok {
+				return reply, nil
+			}
+			if strings.EqualFold(where, "here") {
+				return app.weatherHere(userID, room, hereRequest{units: units, verbose: verbose}), nil
+			}
+			locations := app.placesFor(userID, where)
+			if len(locations) == 0 {
+				return "@pemit " + userID + "=Gravybot: no location given. Try: gravybot set location <place>\n", nil
+			}
+			if table || byTemp {
+				return "pose W> " + app.compareWeather(locations, units, byTemp), nil
 			}
 			var commands []string
 			for _, loc := range locations {
-				loc = strings.TrimSpace(loc)
-				if loc == "" {
-					continue
-				}
-				loc = parseLatLon(loc)
-				query := url.QueryEscape(loc)
-
-				response, err := app.sendWeatherRequest(query)
+				response, err := app.sendWeatherRequest(parseLatLon(app.resolveLocation(loc)), units, verbose)
 				if err != nil {
 					fmt.Println("GRAVYWEATHER request fail")
 					fmt.Println(err)
@@ -1644,15 +2021,22 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 		}
 	}
 
-	re = regexp.MustCompile(`(?i)\[.*\(#\d+\)\] .+ says "(gbs|Gravybot\,? stock) (.+)"$`)
-	s = re.FindSubmatch([]byte(line))
+	re = regexp.MustCompile(`(?i)^(gbs|Gravybot\,? stock)(?:\s+(.*))?$`)
+	s = re.FindSubmatch([]byte(text))
 
 	if s != nil {
 		if len(s) < 3 {
 			fmt.Println("GBS wrong len")
 			return "", nil
 		} else {
-			symbols := strings.Split(string(s[2]), ",")
+			list := strings.TrimSpace(string(s[2]))
+			if list == "" {
+				list = strings.Join(app.prefs.get(userID).Stocks, ",")
+			}
+			if list == "" {
+				return "@pemit " + userID + "=Gravybot: no stock given. Try: gravybot set stocks AAPL,c:btc\n", nil
+			}
+			symbols := strings.Split(list, ",")
 			if len(symbols) > 5 {
 				symbols = symbols[:5]
 			}
@@ -1665,7 +2049,9 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 
 				if strings.HasPrefix(strings.ToLower(sym), "c:") {
 					cryptoQuery := sym[2:]
-					response, err := app.getCryptoQuote(cryptoQuery)
+					response, err := cachedReply(app.cache, "quotes", "crypto|"+strings.ToLower(cryptoQuery), "Crypto error", func() (string, error) {
+						return app.getCryptoQuote(cryptoQuery)
+					})
 					if err != nil {
 						fmt.Println("GBC request fail")
 						fmt.Println(err)
@@ -1673,7 +2059,9 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 					}
 					commands = append(commands, "pose S> "+response)
 				} else {
-					response, err := app.getStockQuote(sym)
+					response, err := cachedReply(app.cache, "quotes", "stock|"+strings.ToLower(sym), "Stock error", func() (string, error) {
+						return app.getStockQuote(sym)
+					})
 					if err != nil {
 						fmt.Println("GBS request fail")
 						fmt.Println(err)
@@ -1687,8 +2075,8 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 		}
 	}
 
-	re = regexp.MustCompile(`(?i)\[.*\(#\d+\)\] .+ says "gravybot horoscope (#\d+)"$`)
-	s = re.FindSubmatch([]byte(line))
+	re = regexp.MustCompile(`(?i)^gravybot horoscope (#\d+)$`)
+	s = re.FindSubmatch([]byte(text))
 
 	if s != nil {
 		if len(s) < 2 {
@@ -1706,35 +2094,18 @@ func (app *application) checkLineForRegexps(line string) (string, error) {
 	return "", nil
 }
 
-func (app *application) processUrls(authorID string, urls [][]byte) (string, error) {
-	var botData string = ""
-	if len(urls) > 0 {
-		for _, urlBytes := range urls {
-			longUrl := string(urlBytes)
-			if strings.HasPrefix(strings.ToLower(longUrl), "www") {
-				longUrl = "http://" + longUrl
-			}
-			u, err := url.Parse(longUrl)
-			if err != nil {
-				return "", err
-			}
-
-			shortUrl, err := app.sendUrlToYirp(u.String())
-			if err == nil && shortUrl != "" {
-				botData = botData + "add_url " + authorID + " " + shortUrl + " " + u.String() + "\n"
-				botData = botData + "@trigger me/TRIGGER_LAST_URL\n"
-			}
-		}
-	}
-	app.infoLog.Printf("botData: %s\n", botData)
-
-	return botData, nil
-}
-
 func (c caller) CallTELNET(ctx telnet.Context, w telnet.Writer, r telnet.Reader) {
 	var command string = ""
 	c.app.infoLog.Printf("connect " + c.app.config.username + " <password>\n")
 	w.Write([]byte("connect " + c.app.config.username + " " + c.app.config.password + "\n"))
+	if sync := c.app.syncURLMirror(); sync != "" {
+		c.app.botSend(w, sync)
+	}
+	if c.app.outbox != nil {
+		done := make(chan struct{})
+		defer close(done)
+		go c.app.drainOutbox(w, done)
+	}
 
 	var buffer [1]byte // Seems like the length of the buffer needs to be small, otherwise will have to wait for buffer to fill up.
 	p := buffer[:]
@@ -17,10 +17,25 @@ import (
 // unit-testing handlers that do not make network calls.
 func newTestApp() *application {
 	discard := log.New(io.Discard, "", 0)
-	return &application{
-		infoLog:  discard,
-		errorLog: discard,
-	}
+	st, _ := openStore("")
+	app := &application{
+		config: config{
+			botName:    "gravybot",
+			addressing: "mention",
+		},
+		infoLog:   discard,
+		errorLog:  discard,
+		store:     st,
+		prefs:     newPrefStore(st),
+		urls:      newURLStore(st),
+		links:     newLinkStore(st),
+		extractor: newURLExtractor(defaultURLSchemes),
+		policy:    newURLPolicy(0, nil, nil, nil),
+		state:     &botState{},
+	}
+	app.shorteners = shortenerChain{&yirpShortener{app: app}}
+	app.weather = weatherChain{&weatherAPIProvider{app: app}}
+	return app
 }
 
 // ── generateHoroscope ────────────────────────────────────────────────────────
@@ -290,6 +305,127 @@ func TestCheckLine_NoMatch(t *testing.T) {
 	}
 }
 
+// ── checkLineForRegexps – addressing ─────────────────────────────────────────
+
+func TestCommandText(t *testing.T) {
+	app := newTestApp()
+	cases := []struct {
+		rest string
+		want string
+	}{
+		// says are passed through untouched
+		{`Dino says "gravybot weather Paris"`, "gravybot weather Paris"},
+		{`Dino says "gbs AAPL"`, "gbs AAPL"},
+		{`Dino says "Gravybot, stock AAPL"`, "gravybot, stock AAPL"},
+		// say that mentions the bot mid-sentence
+		{`Dino says "hey gravybot weather Paris?"`, "gravybot weather Paris"},
+		// poses and semiposes
+		{`Dino asks gravybot weather Paris`, "gravybot weather Paris"},
+		{`Dino asks Gravybot, weather Paris.`, "gravybot weather Paris"},
+		{`Dino's cat begs gravybot horoscope #401`, "gravybot horoscope #401"},
+		// @emit with no speaker name at all
+		{`The wind whispers: gravybot stock AAPL`, "gravybot stock AAPL"},
+		// bot named but no command word
+		{`Dino waves at gravybot.`, ""},
+		{`Dino pats gravybot gently`, ""},
+		// command word without the bot name
+		{`Dino checks the weather in Paris`, ""},
+		// everyday words work at the start of a say; elsewhere they need a
+		// comma or colon after the name
+		{`Dino says "gravybot set units metric"`, "gravybot set units metric"},
+		{`Dino says "gravybot: set units metric"`, "gravybot, set units metric"},
+		{`Dino says "I have no time, gravybot time to go"`, "I have no time, gravybot time to go"},
+		{`Dino asks gravybot time to go`, ""},
+		{`Dino asks gravybot: sun Paris`, "gravybot sun Paris"},
+	}
+	for _, tc := range cases {
+		if got := app.commandText(tc.rest); got != tc.want {
+			t.Errorf("commandText(%q) = %q, want %q", tc.rest, got, tc.want)
+		}
+	}
+}
+
+func TestCheckLine_CasualChatIgnored(t *testing.T) {
+	app := newTestApp()
+	for _, line := range []string{
+		`[Dino(#1234)] Dino says "I have no time, gravybot time to go"`,
+		`[Dino(#1234)] Dino asks gravybot set the table`,
+		`[Dino(#1234)] Dino tells gravybot location is everything`,
+	} {
+		if got, _ := app.checkLineForRegexps(line); got != "" {
+			t.Errorf("%s\nanswered %q", line, got)
+		}
+	}
+}
+
+// The syntax from the backlog requests, said plainly.
+func TestCheckLine_GenericWordsLeadingASay(t *testing.T) {
+	var aqi []string
+	srv := newDetailServer(t, &aqi)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot set location London"`)
+	if !strings.Contains(got, "location set to London") {
+		t.Errorf("set: %q", got)
+	}
+	if got, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot prefs"`); !strings.Contains(got, "London") {
+		t.Errorf("prefs: %q", got)
+	}
+	if got, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot sun London"`); !strings.HasPrefix(got, "pose S> London") {
+		t.Errorf("sun: %q", got)
+	}
+	got, _ = app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot location add dino 39.7,-104.9"`)
+	if !strings.Contains(got, "dino") {
+		t.Errorf("location add: %q", got)
+	}
+	if got, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot time UTC"`); got == "" {
+		t.Error("time: no reply")
+	}
+}
+
+func TestCommandText_SayAddressing(t *testing.T) {
+	app := newTestApp()
+	app.config.addressing = "say"
+	if got := app.commandText(`Dino asks gravybot weather Paris`); got != "" {
+		t.Errorf("say addressing should ignore poses, got %q", got)
+	}
+	if got := app.commandText(`Dino says "gravybot weather Paris"`); got != "gravybot weather Paris" {
+		t.Errorf("say addressing should accept says, got %q", got)
+	}
+}
+
+func TestCommandText_CustomBotName(t *testing.T) {
+	app := newTestApp()
+	app.config.botName = "Xeph"
+	if got := app.commandText(`Dino says "xeph weather Paris"`); got != "gravybot weather Paris" {
+		t.Errorf("custom name say: got %q", got)
+	}
+	if got := app.commandText(`Dino asks Xeph horoscope #1`); got != "gravybot horoscope #1" {
+		t.Errorf("custom name pose: got %q", got)
+	}
+}
+
+func TestCheckLine_HoroscopeFromPose(t *testing.T) {
+	app := newTestApp()
+	lines := []string{
+		`[Dino(#1234)] Dino asks gravybot horoscope #401`,
+		`[Dino(#1234)] Dino's crystal ball asks gravybot horoscope #401`,
+		`[Dino(#1234)] A voice booms, "gravybot horoscope #401"`,
+	}
+	want, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot horoscope #401"`)
+	for _, line := range lines {
+		cmd, err := app.checkLineForRegexps(line)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", line, err)
+		}
+		if cmd != want {
+			t.Errorf("pose %q gave %q, want %q", line, cmd, want)
+		}
+	}
+}
+
 // ── generateLuckyNumber ───────────────────────────────────────────────────────
 
 func TestGenerateLuckyNumber_NonEmpty(t *testing.T) {
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The gurl softcode reads recent URLs from a ring buffer of attributes on
+// the URL database object (#1818): URL_SHORT_<n>, URL_LONG_<n> and
+// URL_DBREF_<n> for slots 0..URL_MAX-1, with URL_CURRENT naming the newest
+// slot. The URL store is the source of truth; these functions produce the
+// &attr commands that bring the ring buffer in line with it. Record IDs are
+// sequential, so record ID lives in slot (ID-1) mod window.
+//
+// Attribute values are read back with u(), which evaluates them, so they are
+// escaped when set.
+
+// urlSlot is the ring buffer slot of a record.
+func (app *application) urlSlot(id int64) int64 {
+	return (id - 1) % int64(app.config.urlWindow)
+}
+
+// mirrorURLs returns the commands that write recs (oldest first) into their
+// slots and point URL_CURRENT at the last of them. newest is the highest
+// record ID in the store. Nothing is written while the game's ring is being
+// read back; the rewrite that follows the import covers recs.
+func (app *application) mirrorURLs(recs []urlRecord, newest int64) string {
+	app.state.mu.Lock()
+	reading := app.state.ring != nil
+	app.state.mu.Unlock()
+	if len(recs) == 0 || reading {
+		return ""
+	}
+	db := app.config.urlDB
+	var b strings.Builder
+	for _, r := range recs {
+		slot := app.urlSlot(r.ID)
+		fmt.Fprintf(&b, "&URL_SHORT_%d %s=%s\n", slot, db, mushEscape(r.Short))
+		fmt.Fprintf(&b, "&URL_LONG_%d %s=%s\n", slot, db, mushEscape(r.Long))
+		fmt.Fprintf(&b, "&URL_DBREF_%d %s=%s\n", slot, db, r.Poster)
+	}
+	size := int64(app.config.urlWindow)
+	if newest < size {
+		size = newest
+	}
+	fmt.Fprintf(&b, "&URL_MAX %s=%d\n", db, size)
+	fmt.Fprintf(&b, "&URL_CURRENT %s=%d\n", db, app.urlSlot(recs[len(recs)-1].ID))
+	return b.String()
+}
+
+// mirrorEnabled reports whether the ring buffer is maintained from Go rather
+// than by the in-game add_url command.
+func (app *application) mirrorEnabled() bool {
+	return app.config.urlDB != "" && app.config.urlWindow > 0
+}
+
+var (
+	// urlRingRe and urlSlotRe match the game's answers to askURLRing: the
+	// ring's size and newest slot, then one line per slot.
+	urlRingRe = regexp.MustCompile(`^XEPHYR-URLRING: (\d*) (\d*)$`)
+	urlSlotRe = regexp.MustCompile(`^XEPHYR-URL: (\d+) (\S*) (\S*) (\S*)$`)
+)
+
+// urlRing is the game's ring buffer as it is read back before the first
+// mirror write, so history kept by add_url isn't lost.
+type urlRing struct {
+	size    int
+	current int
+	slots   map[int]urlRecord
+}
+
+// syncURLMirror brings the ring buffer and the store together when the bot
+// connects. With history in the store the ring is rewritten from it. With
+// none, the ring may still hold what add_url kept, so the game is asked for
+// it and it is imported before anything is written.
+func (app *application) syncURLMirror() string {
+	if !app.mirrorEnabled() {
+		return ""
+	}
+	recs, err := app.urls.find(nil)
+	if err != nil {
+		app.errorLog.Printf("url mirror: %s", err)
+		return ""
+	}
+	if len(recs) > 0 {
+		return app.reconcileURLMirror()
+	}
+	return app.askURLRing()
+}
+
+// askURLRing has the game pemit the ring's size and newest slot, then each
+// slot's poster, short and long URL.
+func (app *application) askURLRing() string {
+	app.state.mu.Lock()
+	app.state.ring = &urlRing{}
+	app.state.mu.Unlock()
+	db := app.config.urlDB
+	return fmt.Sprintf("@pemit me=XEPHYR-URLRING: [get(%[1]s/URL_MAX)] [get(%[1]s/URL_CURRENT)]\n"+
+		"@dolist [lnum(get(%[1]s/URL_MAX))]=@pemit me=XEPHYR-URL: ## [u(%[1]s/URL_DBREF_##)] [u(%[1]s/URL_SHORT_##)] [u(%[1]s/URL_LONG_##)]\n", db)
+}
+
+// answerURLRing takes one line of the game's answer to askURLRing. Once
+// every slot is in, the ring is imported oldest first and the commands that
+// rewrite it from the store are returned.
+func (app *application) answerURLRing(line string, now time.Time) (string, bool) {
+	app.state.mu.Lock()
+	ring := app.state.ring
+	if ring == nil {
+		app.state.mu.Unlock()
+		return "", urlRingRe.MatchString(line) || urlSlotRe.MatchString(line)
+	}
+	if m := urlRingRe.FindStringSubmatch(line); m != nil {
+		ring.size, _ = strconv.Atoi(m[1])
+		ring.current, _ = strconv.Atoi(m[2])
+		ring.slots = map[int]urlRecord{}
+	} else if m := urlSlotRe.FindStringSubmatch(line); m != nil && ring.slots != nil {
+		slot, _ := strconv.Atoi(m[1])
+		ring.slots[slot] = urlRecord{Poster: m[2], Short: m[3], Long: m[4]}
+	} else {
+		app.state.mu.Unlock()
+		return "", false
+	}
+	if ring.slots == nil || len(ring.slots) < ring.size {
+		app.state.mu.Unlock()
+		return "", true
+	}
+	app.state.ring = nil
+	app.state.mu.Unlock()
+
+	n, err := app.importURLRing(ring, now)
+	if err != nil {
+		app.errorLog.Printf("url mirror import: %s", err)
+		return "", true
+	}
+	app.infoLog.Printf("url mirror: imported %d URLs from the game", n)
+	return app.reconcileURLMirror(), true
+}
+
+// importURLRing adds the ring's URLs to the store, oldest first and ahead of
+// anything posted while the ring was read back.
+func (app *application) importURLRing(ring *urlRing, now time.Time) (int, error) {
+	var recs []urlRecord
+	for i := 1; i <= ring.size; i++ {
+		rec := ring.slots[(ring.current+i)%ring.size]
+		u, err := url.Parse(rec.Long)
+		if rec.Short == "" || err != nil || u.Host == "" {
+			continue
+		}
+		rec.Norm = normalizeURL(u)
+		recs = append(recs, rec)
+	}
+	return len(recs), app.urls.addOlder(recs, now)
+}
+
+// reconcileURLMirror rewrites the whole window from the store, so gurl is
+// right again after the bot or the game restarts.
+func (app *application) reconcileURLMirror() string {
+	if !app.mirrorEnabled() {
+		return ""
+	}
+	recs, err := app.urls.find(nil)
+	if err != nil {
+		app.errorLog.Printf("url mirror: %s", err)
+		return ""
+	}
+	if len(recs) == 0 {
+		return ""
+	}
+	newest := recs[0].ID
+	if len(recs) > app.config.urlWindow {
+		recs = recs[:app.config.urlWindow]
+	}
+	for i, j := 0, len(recs)-1; i < j; i, j = i+1, j-1 {
+		recs[i], recs[j] = recs[j], recs[i]
+	}
+	return app.mirrorURLs(recs, newest)
+}
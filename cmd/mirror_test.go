@@ -0,0 +1,132 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func newMirrorApp(window int) *application {
+	app := newTestApp()
+	app.config.urlDB = "#1818"
+	app.config.urlWindow = window
+	return app
+}
+
+func TestReconcileURLMirror_WrapsWindow(t *testing.T) {
+	app := newMirrorApp(5)
+	seedURLs(t, app, 7, time.Now())
+
+	got := app.reconcileURLMirror()
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if len(lines) != 5*3+2 {
+		t.Fatalf("got %d commands:\n%s", len(lines), got)
+	}
+	// Records 3..7 land in slots 2,3,4,0,1; the newest (7) is current.
+	if lines[0] != "&URL_SHORT_2 #1818=https://y.rp/2" || lines[2] != "&URL_DBREF_2 #1818=#1234" {
+		t.Errorf("oldest record written wrong: %q, %q", lines[0], lines[2])
+	}
+	if lines[12] != "&URL_SHORT_1 #1818=https://y.rp/6" || lines[13] != "&URL_LONG_1 #1818=https://example.com/page/6" {
+		t.Errorf("newest record written wrong: %q, %q", lines[12], lines[13])
+	}
+	if lines[15] != "&URL_MAX #1818=5" || lines[16] != "&URL_CURRENT #1818=1" {
+		t.Errorf("unexpected pointers: %q, %q", lines[15], lines[16])
+	}
+}
+
+func TestReconcileURLMirror_PartialAndDisabled(t *testing.T) {
+	app := newMirrorApp(50)
+	if got := app.reconcileURLMirror(); got != "" {
+		t.Errorf("empty history produced %q", got)
+	}
+	seedURLs(t, app, 3, time.Now())
+	got := app.reconcileURLMirror()
+	if !strings.HasSuffix(got, "&URL_MAX #1818=3\n&URL_CURRENT #1818=2\n") {
+		t.Errorf("unexpected pointers:\n%s", got)
+	}
+
+	app.config.urlDB = ""
+	if got := app.reconcileURLMirror(); got != "" {
+		t.Errorf("disabled mirror produced %q", got)
+	}
+}
+
+func TestProcessUrls_MirrorsInsteadOfAddURL(t *testing.T) {
+	app := newMirrorApp(2)
+	app.config.urlDupWindow = time.Hour
+	app.shorteners = shortenerChain{&stubShortener{name: "yirp", short: "https://y.rp/s1"}}
+	seedURLs(t, app, 2, time.Now().Add(-time.Hour))
+
+	got, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/a?q=[x]"})
+	want := "&URL_SHORT_0 #1818=https://y.rp/s1\n" +
+		"&URL_LONG_0 #1818=https://example.com/a?q=\\[x\\]\n" +
+		"&URL_DBREF_0 #1818=#42\n" +
+		"&URL_MAX #1818=2\n" +
+		"&URL_CURRENT #1818=0\n" +
+		"@trigger me/TRIGGER_LAST_URL\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+
+	// A repost is recorded too, so it takes the next slot.
+	got, _ = app.processUrls("#43", "Bob", "#100", []string{"https://example.com/a?q=[x]"})
+	if !strings.HasPrefix(got, "&URL_SHORT_1 #1818=https://y.rp/s1\n") || !strings.Contains(got, "&URL_CURRENT #1818=1\n") ||
+		!strings.Contains(got, "first posted by Alice") {
+		t.Errorf("unexpected repost output:\n%s", got)
+	}
+}
+
+func TestSyncURLMirror_ImportsPopulatedRing(t *testing.T) {
+	app := newMirrorApp(50)
+	got := app.syncURLMirror()
+	if !strings.HasPrefix(got, "@pemit me=XEPHYR-URLRING: [get(#1818/URL_MAX)] [get(#1818/URL_CURRENT)]\n@dolist ") {
+		t.Fatalf("query: %q", got)
+	}
+
+	// add_url left four URLs with slot 1 the newest, so slot 2 is the oldest.
+	lines := []string{
+		"XEPHYR-URLRING: 4 1",
+		"XEPHYR-URL: 0 #7 https://y.rp/c https://example.com/c",
+		"XEPHYR-URL: 1 #7 https://y.rp/d https://example.com/d",
+		"XEPHYR-URL: 2 #8 https://y.rp/a https://example.com/a",
+	}
+	for _, line := range lines {
+		if got, _ := app.checkLineForRegexps(line); got != "" {
+			t.Fatalf("%s: wrote %q before the ring was read", line, got)
+		}
+	}
+	// A URL posted meanwhile isn't mirrored yet.
+	app.shorteners = shortenerChain{&stubShortener{name: "yirp", short: "https://y.rp/e"}}
+	if got, _ := app.processUrls("#9", "Ann", "#100", []string{"https://example.com/e/long/enough"}); strings.Contains(got, "&URL_") {
+		t.Errorf("mirrored during import: %q", got)
+	}
+
+	got, _ = app.checkLineForRegexps("XEPHYR-URL: 3 #8 https://y.rp/b https://example.com/b")
+	if !strings.Contains(got, "&URL_MAX #1818=5\n") || !strings.HasSuffix(got, "&URL_CURRENT #1818=4\n") {
+		t.Errorf("rewrite after import:\n%s", got)
+	}
+	recs, _ := app.urls.find(nil)
+	var longs []string
+	for _, r := range recs {
+		longs = append(longs, strings.TrimPrefix(r.Long, "https://example.com/"))
+	}
+	if strings.Join(longs, " ") != "e/long/enough d c b a" || recs[4].Poster != "#8" || recs[4].Norm == "" {
+		t.Errorf("store after import: %q %+v", longs, recs[4])
+	}
+
+	// Once there is history, connecting rewrites the ring instead of asking.
+	if got := app.syncURLMirror(); strings.Contains(got, "XEPHYR-URLRING") || !strings.Contains(got, "&URL_MAX #1818=5") {
+		t.Errorf("second sync: %q", got)
+	}
+}
+
+func TestSyncURLMirror_EmptyRing(t *testing.T) {
+	app := newMirrorApp(50)
+	app.syncURLMirror()
+	if got, _ := app.checkLineForRegexps("XEPHYR-URLRING:  "); got != "" {
+		t.Errorf("empty ring wrote %q", got)
+	}
+	if app.state.ring != nil {
+		t.Error("still reading an empty ring")
+	}
+}
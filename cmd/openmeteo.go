@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openMeteoProvider is Open-Meteo (open-meteo.com), which needs no key.
+// Places are resolved with its geocoding API; coordinates are used as-is.
+// Everything is requested in metric and converted for imperial output.
+type openMeteoProvider struct {
+	app *application
+}
+
+func (p *openMeteoProvider) Name() string { return "openmeteo" }
+
+type openMeteoGeocodeResponse struct {
+	Results []struct {
+		Name        string  `json:"name"`
+		Latitude    float64 `json:"latitude"`
+		Longitude   float64 `json:"longitude"`
+		Country     string  `json:"country"`
+		CountryCode string  `json:"country_code"`
+		Admin1      string  `json:"admin1"`
+	} `json:"results"`
+}
+
+type openMeteoForecastResponse struct {
+	Timezone string `json:"timezone"`
+
+	Current struct {
+		Temperature   float64 `json:"temperature_2m"`
+		Humidity      float64 `json:"relative_humidity_2m"`
+		Apparent      float64 `json:"apparent_temperature"`
+		Precipitation float64 `json:"precipitation"`
+		WeatherCode   int     `json:"weather_code"`
+		WindSpeed     float64 `json:"wind_speed_10m"`
+		WindDirection float64 `json:"wind_direction_10m"`
+		WindGusts     float64 `json:"wind_gusts_10m"`
+		UVIndex       float64 `json:"uv_index"`
+	} `json:"current"`
+
+	Daily struct {
+		Time             []string  `json:"time"`
+		WeatherCode      []int     `json:"weather_code"`
+		TemperatureMax   []float64 `json:"temperature_2m_max"`
+		TemperatureMin   []float64 `json:"temperature_2m_min"`
+		PrecipitationSum []float64 `json:"precipitation_sum"`
+		PrecipitationMax []float64 `json:"precipitation_probability_max"`
+		Sunrise          []string  `json:"sunrise"`
+		Sunset           []string  `json:"sunset"`
+	} `json:"daily"`
+}
+
+type openMeteoAirQualityResponse struct {
+	Current struct {
+		USAQI *float64 `json:"us_aqi"`
+		PM2_5 float64  `json:"pm2_5"`
+		PM10  float64  `json:"pm10"`
+		O3    float64  `json:"ozone"`
+		NO2   float64  `json:"nitrogen_dioxide"`
+	} `json:"current"`
+}
+
+var latLonRe = regexp.MustCompile(`^(-?\d+(?:\.\d+)?),(-?\d+(?:\.\d+)?)$`)
+
+func (p *openMeteoProvider) get(u string, v any) error {
+	res, err := weatherClient.Get(u)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return &weatherStatusError{provider: p.Name(), code: res.StatusCode}
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// locate resolves loc to coordinates. A "city state" or "city country" pair
+// is looked up by city and matched against the state, country or country
+// code, since the geocoder only searches names.
+func (p *openMeteoProvider) locate(loc string) (weatherPlace, string, string, error) {
+	if m := latLonRe.FindStringSubmatch(loc); m != nil {
+		return weatherPlace{Name: loc}, m[1], m[2], nil
+	}
+	search := func(name string, count int) (openMeteoGeocodeResponse, error) {
+		params := url.Values{"name": {name}, "count": {strconv.Itoa(count)}, "language": {"en"}, "format": {"json"}}
+		var gr openMeteoGeocodeResponse
+		err := p.get(p.app.config.openMeteoGeoURL+"/search?"+params.Encode(), &gr)
+		return gr, err
+	}
+	gr, err := search(loc, 1)
+	if err != nil {
+		return weatherPlace{}, "", "", err
+	}
+	if len(gr.Results) == 0 {
+		words := strings.Fields(loc)
+		if len(words) < 2 {
+			return weatherPlace{}, "", "", errLocationNotFound
+		}
+		qualifier := strings.ToLower(words[len(words)-1])
+		if gr, err = search(strings.Join(words[:len(words)-1], " "), 10); err != nil {
+			return weatherPlace{}, "", "", err
+		}
+		kept := gr.Results[:0]
+		for _, r := range gr.Results {
+			if strings.EqualFold(r.CountryCode, qualifier) || strings.HasPrefix(strings.ToLower(r.Admin1), qualifier) ||
+				strings.HasPrefix(strings.ToLower(r.Country), qualifier) {
+				kept = append(kept, r)
+			}
+		}
+		if len(kept) == 0 {
+			return weatherPlace{}, "", "", errLocationNotFound
+		}
+		gr.Results = kept
+	}
+	r := gr.Results[0]
+	place := weatherPlace{Name: r.Name, Region: r.Admin1, Country: r.Country}
+	if r.CountryCode == "US" {
+		place.Country = "United States of America"
+	}
+	return place, strconv.FormatFloat(r.Latitude, 'f', -1, 64), strconv.FormatFloat(r.Longitude, 'f', -1, 64), nil
+}
+
+// fetchInto locates loc and asks the endpoint, a base URL and path, about
+// it, decoding the answer into v.
+func (p *openMeteoProvider) fetchInto(loc, endpoint string, params url.Values, v any) (weatherPlace, error) {
+	place, lat, lon, err := p.locate(loc)
+	if err != nil {
+		return place, err
+	}
+	params.Set("latitude", lat)
+	params.Set("longitude", lon)
+	params.Set("timezone", "auto")
+	return place, p.get(endpoint+"?"+params.Encode(), v)
+}
+
+// fetch is fetchInto for the forecast and archive endpoints.
+func (p *openMeteoProvider) fetch(loc, endpoint string, params url.Values) (weatherPlace, openMeteoForecastResponse, error) {
+	var fr openMeteoForecastResponse
+	place, err := p.fetchInto(loc, endpoint, params, &fr)
+	place.TZ = fr.Timezone
+	return place, fr, err
+}
+
+func (p *openMeteoProvider) Current(loc string) (weatherReport, error) {
+	params := url.Values{"current": {"temperature_2m,relative_humidity_2m,apparent_temperature,precipitation," +
+		"weather_code,wind_speed_10m,wind_direction_10m,wind_gusts_10m,uv_index"}}
+	place, fr, err := p.fetch(loc, p.app.config.openMeteoBaseURL+"/forecast", params)
+	if err != nil {
+		return weatherReport{}, err
+	}
+	c := fr.Current
+	return weatherReport{
+		Place:     place,
+		Condition: wmoCondition(c.WeatherCode),
+		TempC:     c.Temperature,
+		TempF:     celsiusToF(c.Temperature),
+		FeelsC:    c.Apparent,
+		FeelsF:    celsiusToF(c.Apparent),
+		Humidity:  c.Humidity,
+		WindKph:   c.WindSpeed,
+		WindMph:   kphToMph(c.WindSpeed),
+		GustKph:   c.WindGusts,
+		GustMph:   kphToMph(c.WindGusts),
+		WindDir:   compassPoint(c.WindDirection),
+		PrecipMm:  c.Precipitation,
+		PrecipIn:  mmToIn(c.Precipitation),
+		UV:        c.UVIndex,
+	}, nil
+}
+
+func (p *openMeteoProvider) Forecast(loc string, days int) (weatherForecast, error) {
+	params := url.Values{
+		"daily":         {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum,precipitation_probability_max"},
+		"forecast_days": {strconv.Itoa(days)},
+	}
+	place, fr, err := p.fetch(loc, p.app.config.openMeteoBaseURL+"/forecast", params)
+	if err != nil {
+		return weatherForecast{}, err
+	}
+	return weatherForecast{Place: place, Days: fr.days()}, nil
+}
+
+// openMeteoRecentDays is how far back the forecast API keeps past days.
+// Older days come from the archive, which runs a few days behind.
+const openMeteoRecentDays = 90
+
+func (p *openMeteoProvider) History(loc string, date time.Time) (weatherForecast, error) {
+	day := date.Format("2006-01-02")
+	params := url.Values{
+		"daily":      {"weather_code,temperature_2m_max,temperature_2m_min,precipitation_sum"},
+		"start_date": {day},
+		"end_date":   {day},
+	}
+	endpoint := p.app.config.openMeteoBaseURL + "/forecast"
+	if time.Since(date) > openMeteoRecentDays*24*time.Hour {
+		endpoint = p.app.config.openMeteoArchiveURL + "/archive"
+	}
+	place, fr, err := p.fetch(loc, endpoint, params)
+	if err != nil {
+		return weatherForecast{}, err
+	}
+	return weatherForecast{Place: place, Days: fr.days()}, nil
+}
+
+func (p *openMeteoProvider) AirQuality(loc string) (airQuality, error) {
+	params := url.Values{"current": {"us_aqi,pm2_5,pm10,ozone,nitrogen_dioxide"}}
+	var ar openMeteoAirQualityResponse
+	place, err := p.fetchInto(loc, p.app.config.openMeteoAirURL+"/air-quality", params, &ar)
+	if err != nil {
+		return airQuality{}, err
+	}
+	c := ar.Current
+	a := airQuality{Place: place}
+	if c.USAQI != nil {
+		a.Category, a.PM2_5, a.PM10, a.O3, a.NO2 = epaIndex(*c.USAQI), c.PM2_5, c.PM10, c.O3, c.NO2
+	}
+	return a, nil
+}
+
+// epaIndex turns a US AQI value into its EPA category, 1 to 6.
+func epaIndex(aqi float64) int {
+	for i, top := range []float64{50, 100, 150, 200, 300} {
+		if aqi <= top {
+			return i + 1
+		}
+	}
+	return 6
+}
+
+// Astronomy reports sunrise and sunset from the forecast. Open-Meteo has no
+// moon data, so the phase is worked out from the date.
+func (p *openMeteoProvider) Astronomy(loc string) (astronomy, error) {
+	params := url.Values{"daily": {"sunrise,sunset"}, "forecast_days": {"1"}}
+	place, fr, err := p.fetch(loc, p.app.config.openMeteoBaseURL+"/forecast", params)
+	if err != nil {
+		return astronomy{}, err
+	}
+	d := fr.Daily
+	if len(d.Sunrise) == 0 || len(d.Sunset) == 0 {
+		return astronomy{}, errors.New("no sunrise or sunset in response")
+	}
+	phase, lit := moonPhase(time.Now())
+	return astronomy{
+		Place:            place,
+		Sunrise:          clockTime(d.Sunrise[0]),
+		Sunset:           clockTime(d.Sunset[0]),
+		MoonPhase:        phase,
+		MoonIllumination: lit,
+	}, nil
+}
+
+// clockTime turns Open-Meteo's local "2006-01-02T15:04" into "03:04 PM".
+func clockTime(s string) string {
+	t, err := time.Parse("2006-01-02T15:04", s)
+	if err != nil {
+		return s
+	}
+	return t.Format("03:04 PM")
+}
+
+const synodicMonth = 29.530588853 // days
+
+// knownNewMoon is the new moon of January 6, 2000, from which phases are
+// counted.
+var knownNewMoon = time.Date(2000, 1, 6, 18, 14, 0, 0, time.UTC)
+
+var moonPhases = []string{"New Moon", "Waxing Crescent", "First Quarter", "Waxing Gibbous",
+	"Full Moon", "Waning Gibbous", "Last Quarter", "Waning Crescent"}
+
+// moonPhase names the moon's phase at t and how much of it is lit, in
+// percent. It is accurate to within a day or so, which is plenty for a name.
+func moonPhase(t time.Time) (string, int) {
+	age := math.Mod(t.Sub(knownNewMoon).Hours()/24, synodicMonth)
+	if age < 0 {
+		age += synodicMonth
+	}
+	frac := age / synodicMonth
+	lit := (1 - math.Cos(2*math.Pi*frac)) / 2 * 100
+	return moonPhases[int(frac*8+0.5)%8], int(math.Round(lit))
+}
+
+// days converts the daily block, skipping days with missing values.
+func (fr openMeteoForecastResponse) days() []forecastDay {
+	var days []forecastDay
+	d := fr.Daily
+	for i, day := range d.Time {
+		date, err := time.Parse("2006-01-02", day)
+		if err != nil || i >= len(d.WeatherCode) || i >= len(d.TemperatureMax) || i >= len(d.TemperatureMin) {
+			continue
+		}
+		fd := forecastDay{
+			Date:      date,
+			Condition: wmoCondition(d.WeatherCode[i]),
+			MaxC:      d.TemperatureMax[i],
+			MaxF:      celsiusToF(d.TemperatureMax[i]),
+			MinC:      d.TemperatureMin[i],
+			MinF:      celsiusToF(d.TemperatureMin[i]),
+		}
+		if i < len(d.PrecipitationSum) {
+			fd.PrecipMm, fd.PrecipIn = d.PrecipitationSum[i], mmToIn(d.PrecipitationSum[i])
+		}
+		// Open-Meteo gives one chance of precipitation; call it snow when
+		// snow is what's expected.
+		if i < len(d.PrecipitationMax) {
+			if wmoSnow(d.WeatherCode[i]) {
+				fd.SnowChance = int(d.PrecipitationMax[i])
+			} else {
+				fd.RainChance = int(d.PrecipitationMax[i])
+			}
+		}
+		days = append(days, fd)
+	}
+	return days
+}
+
+func celsiusToF(c float64) float64 { return c*9/5 + 32 }
+func kphToMph(k float64) float64   { return k / 1.609344 }
+func mmToIn(mm float64) float64    { return mm / 25.4 }
+
+var compassPoints = []string{"N", "NNE", "NE", "ENE", "E", "ESE", "SE", "SSE", "S", "SSW", "SW", "WSW", "W", "WNW", "NW", "NNW"}
+
+// compassPoint turns a bearing in degrees into one of 16 compass points.
+func compassPoint(deg float64) string {
+	i := int(deg/22.5+0.5) % 16
+	if i < 0 {
+		i += 16
+	}
+	return compassPoints[i]
+}
+
+// wmoConditions describes WMO weather interpretation codes.
+var wmoConditions = map[int]string{
+	0: "Clear", 1: "Mainly clear", 2: "Partly cloudy", 3: "Overcast",
+	45: "Fog", 48: "Freezing fog",
+	51: "Light drizzle", 53: "Drizzle", 55: "Heavy drizzle", 56: "Light freezing drizzle", 57: "Freezing drizzle",
+	61: "Light rain", 63: "Moderate rain", 65: "Heavy rain", 66: "Light freezing rain", 67: "Freezing rain",
+	71: "Light snow", 73: "Moderate snow", 75: "Heavy snow", 77: "Snow grains",
+	80: "Light showers", 81: "Showers", 82: "Violent showers", 85: "Light snow showers", 86: "Snow showers",
+	95: "Thunderstorm", 96: "Thunderstorm with hail", 99: "Thunderstorm with heavy hail",
+}
+
+func wmoCondition(code int) string {
+	if c, ok := wmoConditions[code]; ok {
+		return c
+	}
+	return fmt.Sprintf("Weather code %d", code)
+}
+
+func wmoSnow(code int) bool {
+	return (code >= 71 && code <= 77) || code == 85 || code == 86
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// defaultShortDomains are link shorteners whose URLs are recorded as posted
+// rather than shortened again.
+var defaultShortDomains = []string{
+	"bit.ly", "buff.ly", "cutt.ly", "goo.gl", "is.gd", "ow.ly", "rb.gy",
+	"rebrand.ly", "shorturl.at", "t.co", "t.ly", "tiny.cc", "tinyurl.com",
+	"yirp.org", "youtu.be",
+}
+
+// urlAction is what the policy decides to do with a posted URL.
+type urlAction int
+
+const (
+	urlShorten     urlAction = iota // shorten, record and announce
+	urlPassThrough                  // record and announce as posted
+	urlIgnore                       // leave it alone entirely
+)
+
+// urlPolicy decides which posted URLs are worth shortening. Domain patterns
+// are globs matched against the hostname; a leading "*." also matches the
+// bare domain. When allow is non-empty only matching hosts are captured.
+type urlPolicy struct {
+	minLength    int
+	shortDomains []string
+	allow        []string
+	deny         []string
+}
+
+func newURLPolicy(minLength int, shortDomains, allow, deny []string) *urlPolicy {
+	clean := func(list []string) []string {
+		var out []string
+		for _, s := range list {
+			if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return &urlPolicy{
+		minLength:    minLength,
+		shortDomains: clean(shortDomains),
+		allow:        clean(allow),
+		deny:         clean(deny),
+	}
+}
+
+// decide applies the policy to u.
+func (p *urlPolicy) decide(u *url.URL) urlAction {
+	host := strings.ToLower(u.Hostname())
+	if domainListed(host, p.deny) || (len(p.allow) > 0 && !domainListed(host, p.allow)) {
+		return urlIgnore
+	}
+	for _, d := range p.shortDomains {
+		if hostIs(host, d) {
+			return urlPassThrough
+		}
+	}
+	if len(u.String()) < p.minLength {
+		return urlPassThrough
+	}
+	return urlShorten
+}
+
+// domainListed reports whether host matches any of the glob patterns.
+func domainListed(host string, patterns []string) bool {
+	for _, pat := range patterns {
+		if ok, _ := path.Match(pat, host); ok {
+			return true
+		}
+		if strings.HasPrefix(pat, "*.") && host == pat[2:] {
+			return true
+		}
+	}
+	return false
+}
+
+const urlRoomsOffBucket = "urls_off"
+
+// urlCaptureOff reports whether URL capture has been switched off where the
+// line was said.
+func (app *application) urlCaptureOff(where string) bool {
+	if where == "" {
+		return false
+	}
+	off, _, err := repo[bool]{st: app.store, bucket: urlRoomsOffBucket}.get(where)
+	if err != nil {
+		app.errorLog.Printf("url capture setting %s: %s", where, err)
+	}
+	return off
+}
+
+// handleURLRoom implements "gravybot urls room on|off [#room|channel:<name>]"
+// for admins. Without a location it applies to the room the bot is in.
+func (app *application) handleURLRoom(userID string, args []string) string {
+	if !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: only admins can change URL capture for a room.\n"
+	}
+	if len(args) == 0 || (!strings.EqualFold(args[0], "on") && !strings.EqualFold(args[0], "off")) {
+		return "@pemit " + userID + "=Gravybot: try gravybot urls room on|off \\[#room\\]\n"
+	}
+	where := app.state.currentRoom()
+	if len(args) > 1 {
+		where = args[1]
+	}
+	if where == "" {
+		return "@pemit " + userID + "=Gravybot: I don't know which room I'm in; name one.\n"
+	}
+
+	r := repo[bool]{st: app.store, bucket: urlRoomsOffBucket}
+	var err error
+	state := "on"
+	if strings.EqualFold(args[0], "off") {
+		err = r.put(where, true)
+		state = "off"
+	} else {
+		err = r.delete(where)
+	}
+	if err != nil {
+		app.errorLog.Printf("url capture setting %s: %s", where, err)
+		return "@pemit " + userID + "=Gravybot: couldn't save that setting.\n"
+	}
+	return "@pemit " + userID + "=Gravybot: URL capture " + state + " for " + mushEscape(where) + ".\n"
+}
+
+// handleURLOptOut implements "gravybot urls optout" and "urls optin".
+func (app *application) handleURLOptOut(userID string, out bool) string {
+	value, reply := "", "Gravybot: your URLs will be captured again."
+	if out {
+		value, reply = "off", "Gravybot: your URLs will no longer be captured. Undo with gravybot urls optin."
+	}
+	if _, err := app.prefs.set(userID, "urls", value); err != nil {
+		app.errorLog.Printf("url optout %s: %s", userID, err)
+		return "@pemit " + userID + "=Gravybot: couldn't save that setting.\n"
+	}
+	return "@pemit " + userID + "=" + reply + "\n"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestURLPolicy_Decide(t *testing.T) {
+	p := newURLPolicy(25, defaultShortDomains, nil, []string{"*.evil.example", "tracker.example"})
+	tests := []struct {
+		raw  string
+		want urlAction
+	}{
+		{"https://example.com/some/long/article", urlShorten},
+		{"https://a.co/x", urlPassThrough},                       // under minimum length
+		{"https://bit.ly/3abcdefghijklmnop", urlPassThrough},     // known shortener
+		{"https://www.t.co/abcdefghijklmnopqrs", urlPassThrough}, // shortener subdomain
+		{"https://youtu.be/dQw4w9WgXcQ?t=42", urlPassThrough},
+		{"https://evil.example/long/enough/path", urlIgnore},
+		{"https://cdn.evil.example/long/enough/path", urlIgnore},
+		{"https://tracker.example/long/enough/path", urlIgnore},
+		{"https://sub.tracker.example/long/enough/path", urlShorten}, // no glob, exact only
+		{"https://notevil.example/long/enough/path", urlShorten},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.raw)
+		if got := p.decide(u); got != tt.want {
+			t.Errorf("decide(%s) = %d, want %d", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestURLPolicy_AllowList(t *testing.T) {
+	p := newURLPolicy(0, nil, []string{"*.example.org", " GitHub.com "}, nil)
+	tests := map[string]urlAction{
+		"https://example.org/a":      urlShorten,
+		"https://docs.example.org/a": urlShorten,
+		"https://github.com/a/b":     urlShorten,
+		"https://gitlab.com/a/b":     urlIgnore,
+	}
+	for raw, want := range tests {
+		u, _ := url.Parse(raw)
+		if got := p.decide(u); got != want {
+			t.Errorf("decide(%s) = %d, want %d", raw, got, want)
+		}
+	}
+}
+
+func TestProcessUrls_PolicyPassThroughAndIgnore(t *testing.T) {
+	app := newTestApp()
+	short := &stubShortener{name: "yirp", short: "https://y.rp/s1"}
+	app.shorteners = shortenerChain{short}
+	app.policy = newURLPolicy(0, []string{"bit.ly"}, nil, []string{"blocked.example"})
+
+	out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://bit.ly/abc", "https://blocked.example/x"})
+	if out != "add_url #42 https://bit.ly/abc https://bit.ly/abc\n@trigger me/TRIGGER_LAST_URL\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	if short.calls != 0 {
+		t.Errorf("shortener called %d times", short.calls)
+	}
+	recs, _ := app.urls.find(nil)
+	if len(recs) != 1 || recs[0].Short != "https://bit.ly/abc" {
+		t.Errorf("unexpected history %+v", recs)
+	}
+}
+
+func TestHandleURLs_OptOut(t *testing.T) {
+	app := newTestApp()
+	app.shorteners = shortenerChain{&stubShortener{name: "yirp", short: "https://y.rp/s1"}}
+
+	if got := app.handleURLs("#42", "optout", time.Now()); !strings.Contains(got, "no longer be captured") {
+		t.Errorf("optout reply %q", got)
+	}
+	if out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/a"}); out != "" {
+		t.Errorf("opted-out player's URL captured: %q", out)
+	}
+	if got := app.prefs.get("#42").String(); got != "urls=off" {
+		t.Errorf("prefs = %q", got)
+	}
+
+	app.handleURLs("#42", "optin", time.Now())
+	if out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/a"}); out == "" {
+		t.Error("opted-in player's URL not captured")
+	}
+}
+
+func TestHandleURLs_Room(t *testing.T) {
+	app := newTestApp()
+	app.config.admins = "#1, #2"
+	app.shorteners = shortenerChain{&stubShortener{name: "yirp", short: "https://y.rp/s1"}}
+	app.state.setRoom("#100", "Lobby")
+
+	if got := app.handleURLs("#42", "room off", time.Now()); !strings.Contains(got, "only admins") {
+		t.Errorf("non-admin reply %q", got)
+	}
+	if got := app.handleURLs("#2", "room off", time.Now()); got != "@pemit #2=Gravybot: URL capture off for #100.\n" {
+		t.Errorf("admin reply %q", got)
+	}
+	if got := app.handleURLs("#2", "room off channel:Public", time.Now()); !strings.Contains(got, "channel:Public") {
+		t.Errorf("channel reply %q", got)
+	}
+	if out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/a"}); out != "" {
+		t.Errorf("URL captured in disabled room: %q", out)
+	}
+	if out, _ := app.processUrls("#42", "Alice", "#200", []string{"https://example.com/a"}); out == "" {
+		t.Error("URL not captured in another room")
+	}
+
+	app.handleURLs("#1", "room on", time.Now())
+	if out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/b"}); out == "" {
+		t.Error("URL not captured after re-enabling room")
+	}
+	if got := app.handleURLs("#1", "room sideways", time.Now()); !strings.Contains(got, "room on|off") {
+		t.Errorf("usage reply %q", got)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	_ "time/tzdata" // the alpine image ships without zoneinfo
+)
+
+// playerPrefs holds the defaults a player has asked the bot to remember. They
+// are used whenever the matching command is issued without arguments.
+type playerPrefs struct {
+	Location string   `json:"location,omitempty"`
+	Units    string   `json:"units,omitempty"`
+	Stocks   []string `json:"stocks,omitempty"`
+	Lang     string   `json:"lang,omitempty"`
+	Timezone string   `json:"tz,omitempty"`
+	URLs     string   `json:"urls,omitempty"` // "off" when the player opted out of URL capture
+}
+
+const prefsBucket = "prefs"
+
+// prefStore keeps playerPrefs keyed by dbref in the prefs bucket.
+type prefStore struct {
+	repo repo[playerPrefs]
+}
+
+var langCodeRe = regexp.MustCompile(`(?i)^(auto|[a-z]{2,3}(-[a-z]{2,4})?)$`)
+
+// prefKeys maps every accepted spelling of a preference to its canonical key.
+var prefKeys = map[string]string{
+	"location":  "location",
+	"loc":       "location",
+	"units":     "units",
+	"unit":      "units",
+	"stocks":    "stocks",
+	"stock":     "stocks",
+	"watchlist": "stocks",
+	"lang":      "lang",
+	"language":  "lang",
+	"tz":        "tz",
+	"timezone":  "tz",
+	"urls":      "urls",
+}
+
+func newPrefStore(st *store) *prefStore {
+	return &prefStore{repo: repo[playerPrefs]{st: st, bucket: prefsBucket}}
+}
+
+func (ps *prefStore) get(dbref string) playerPrefs {
+	p, _, _ := ps.repo.get(dbref)
+	return p
+}
+
+// set validates and stores a single preference, returning the value as it was
+// saved. An empty value clears the preference.
+func (ps *prefStore) set(dbref, key, value string) (string, error) {
+	canonical, ok := prefKeys[strings.ToLower(key)]
+	if !ok {
+		return "", fmt.Errorf("unknown setting '%s'", key)
+	}
+	value, err := normalizePref(canonical, strings.TrimSpace(value))
+	if err != nil {
+		return "", err
+	}
+
+	// Read and write in one update so concurrent sets of different keys for
+	// the same player don't overwrite each other.
+	err = ps.repo.st.update(func(tx *storeTx) error {
+		var p playerPrefs
+		if _, err := tx.get(prefsBucket, dbref, &p); err != nil {
+			return err
+		}
+		switch canonical {
+		case "location":
+			p.Location = value
+		case "units":
+			p.Units = value
+		case "stocks":
+			p.Stocks = nil
+			if value != "" {
+				p.Stocks = strings.Split(value, ",")
+			}
+		case "lang":
+			p.Lang = value
+		case "tz":
+			p.Timezone = value
+		case "urls":
+			p.URLs = value
+		}
+		if p.empty() {
+			return tx.delete(prefsBucket, dbref)
+		}
+		return tx.put(prefsBucket, dbref, p)
+	})
+	return value, err
+}
+
+func normalizePref(key, value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	switch key {
+	case "units":
+		switch strings.ToLower(value) {
+		case "metric", "c", "celsius":
+			return "metric", nil
+		case "imperial", "f", "fahrenheit", "us":
+			return "imperial", nil
+		case "both", "b":
+			return "both", nil
+		}
+		return "", fmt.Errorf("units must be metric, imperial or both")
+	case "stocks":
+		fields := strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == ' ' })
+		if len(fields) > 5 {
+			return "", fmt.Errorf("at most 5 stocks can be watched")
+		}
+		for i := range fields {
+			fields[i] = strings.ToUpper(fields[i])
+		}
+		return strings.Join(fields, ","), nil
+	case "lang":
+		if !langCodeRe.MatchString(value) {
+			return "", fmt.Errorf("'%s' is not a language code", value)
+		}
+		return strings.ToLower(value), nil
+	case "tz":
+		if _, err := time.LoadLocation(value); err != nil {
+			return "", fmt.Errorf("unknown time zone '%s'", value)
+		}
+		return value, nil
+	case "urls":
+		switch strings.ToLower(value) {
+		case "off", "no", "optout":
+			return "off", nil
+		case "on", "yes", "optin":
+			return "", nil
+		}
+		return "", fmt.Errorf("urls must be on or off")
+	}
+	return value, nil
+}
+
+func (p playerPrefs) empty() bool {
+	return p.Location == "" && p.Units == "" && len(p.Stocks) == 0 && p.Lang == "" && p.Timezone == "" && p.URLs == ""
+}
+
+func (p playerPrefs) String() string {
+	var parts []string
+	add := func(k, v string) {
+		if v != "" {
+			parts = append(parts, k+"="+v)
+		}
+	}
+	add("location", p.Location)
+	add("units", p.Units)
+	add("stocks", strings.Join(p.Stocks, ","))
+	add("lang", p.Lang)
+	add("tz", p.Timezone)
+	add("urls", p.URLs)
+	if len(parts) == 0 {
+		return "none"
+	}
+	return strings.Join(parts, " ")
+}
+
+// handleSet implements "gravybot set <key> [value]".
+func (app *application) handleSet(userID, key, value string) string {
+	saved, err := app.prefs.set(userID, key, value)
+	if err != nil {
+		app.errorLog.Printf("pref set %s %s: %s", userID, key, err)
+		keys := make([]string, 0, len(prefKeys))
+		for k, v := range prefKeys {
+			if k == v {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		return fmt.Sprintf("@pemit %s=Gravybot: %s. Settings: %s\n", userID, mushEscape(err.Error()), strings.Join(keys, ", "))
+	}
+	if saved == "" {
+		return fmt.Sprintf("@pemit %s=Gravybot: %s cleared.\n", userID, prefKeys[strings.ToLower(key)])
+	}
+	return fmt.Sprintf("@pemit %s=Gravybot: %s set to %s.\n", userID, prefKeys[strings.ToLower(key)], mushEscape(saved))
+}
+
+// localTime formats the current time in the named zone, or the player's saved
+// zone when none is given. A name that isn't a zone is looked up as a place.
+func (app *application) localTime(userID, zone string, now time.Time) string {
+	if zone == "" {
+		zone = app.prefs.get(userID).Timezone
+	}
+	if zone == "" {
+		return "Time error: no time zone given. Try: gravybot set tz America/Denver\n"
+	}
+	loc, err := time.LoadLocation(zone)
+	if err != nil {
+		if loc, err = app.zoneForPlace(zone); err != nil {
+			return "Time error: unknown time zone or place " + mushEscape(zone) + "\n"
+		}
+	}
+	return now.In(loc).Format("Mon Jan 2 15:04 MST") + " (" + loc.String() + ")\n"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPrefStore_SetNormalizes(t *testing.T) {
+	ps := newTestApp().prefs
+	cases := []struct {
+		key, value, want string
+	}{
+		{"location", "  Denver, CO ", "Denver, CO"},
+		{"units", "F", "imperial"},
+		{"unit", "celsius", "metric"},
+		{"watchlist", "aapl, msft c:btc", "AAPL,MSFT,C:BTC"},
+		{"language", "FR", "fr"},
+		{"timezone", "America/Denver", "America/Denver"},
+	}
+	for _, tc := range cases {
+		got, err := ps.set("#1", tc.key, tc.value)
+		if err != nil {
+			t.Fatalf("set(%q, %q): %v", tc.key, tc.value, err)
+		}
+		if got != tc.want {
+			t.Errorf("set(%q, %q) = %q, want %q", tc.key, tc.value, got, tc.want)
+		}
+	}
+	p := ps.get("#1")
+	if p.Location != "Denver, CO" || p.Units != "metric" || len(p.Stocks) != 3 || p.Lang != "fr" || p.Timezone != "America/Denver" {
+		t.Errorf("unexpected prefs after set: %+v", p)
+	}
+}
+
+func TestPrefStore_SetRejectsBadValues(t *testing.T) {
+	ps := newTestApp().prefs
+	bad := [][2]string{
+		{"colour", "blue"},
+		{"units", "kelvin"},
+		{"stocks", "A B C D E F"},
+		{"lang", "not a language"},
+		{"tz", "Mars/Olympus_Mons"},
+	}
+	for _, kv := range bad {
+		if _, err := ps.set("#1", kv[0], kv[1]); err == nil {
+			t.Errorf("set(%q, %q) should have failed", kv[0], kv[1])
+		}
+	}
+	if !ps.get("#1").empty() {
+		t.Errorf("rejected values were stored: %+v", ps.get("#1"))
+	}
+}
+
+func TestPrefStore_ClearRemovesPlayer(t *testing.T) {
+	ps := newTestApp().prefs
+	ps.set("#1", "location", "Paris")
+	ps.set("#1", "location", "")
+	if _, ok, _ := ps.repo.get("#1"); ok {
+		t.Error("clearing the last preference should remove the player entry")
+	}
+}
+
+func TestPrefStore_ConcurrentSetsKeepEveryKey(t *testing.T) {
+	ps := newTestApp().prefs
+	sets := [][2]string{{"location", "Paris"}, {"units", "metric"}, {"lang", "fr"}, {"tz", "Europe/Paris"}, {"stocks", "aapl"}}
+	for i := 0; i < 100; i++ {
+		var wg sync.WaitGroup
+		for _, kv := range sets {
+			wg.Add(1)
+			go func(key, value string) {
+				defer wg.Done()
+				ps.set("#1", key, value)
+			}(kv[0], kv[1])
+		}
+		wg.Wait()
+		p := ps.get("#1")
+		if p.Location != "Paris" || p.Units != "metric" || p.Lang != "fr" || p.Timezone != "Europe/Paris" || len(p.Stocks) != 1 {
+			t.Fatalf("lost a concurrent set: %+v", p)
+		}
+		ps.repo.delete("#1")
+	}
+}
+
+func TestPrefStore_Persists(t *testing.T) {
+	dir := t.TempDir()
+	st, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("open empty store: %v", err)
+	}
+	if _, err := newPrefStore(st).set("#42", "location", "Boston"); err != nil {
+		t.Fatalf("set: %v", err)
+	}
+
+	reopened, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if got := newPrefStore(reopened).get("#42").Location; got != "Boston" {
+		t.Errorf("reloaded location = %q, want Boston", got)
+	}
+}
+
+func TestLocalTime(t *testing.T) {
+	app := newTestApp()
+	now := time.Date(2026, 6, 3, 18, 30, 0, 0, time.UTC)
+
+	if got := app.localTime("#1", "", now); !strings.HasPrefix(got, "Time error:") {
+		t.Errorf("expected error without zone, got %q", got)
+	}
+	app.prefs.set("#1", "tz", "America/Denver")
+	if got := app.localTime("#1", "", now); got != "Wed Jun 3 12:30 MDT (America/Denver)\n" {
+		t.Errorf("saved zone: got %q", got)
+	}
+	if got := app.localTime("#1", "Asia/Tokyo", now); got != "Thu Jun 4 03:30 JST (Asia/Tokyo)\n" {
+		t.Errorf("explicit zone: got %q", got)
+	}
+}
+
+func TestCheckLine_SetAndPrefs(t *testing.T) {
+	app := newTestApp()
+	cmd, err := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot set location [pemit(me,x)]"`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cmd != "@pemit #1234=Gravybot: location set to \\[pemit(me,x)\\].\n" {
+		t.Errorf("unexpected set reply: %q", cmd)
+	}
+
+	cmd, _ = app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot prefs"`)
+	if !strings.Contains(cmd, "location=\\[pemit(me,x)\\]") {
+		t.Errorf("prefs reply missing location: %q", cmd)
+	}
+}
+
+func TestCheckLine_StockWatchlistDefault(t *testing.T) {
+	search := map[string]interface{}{
+		"coins": []map[string]string{
+			{"id": "bitcoin", "symbol": "BTC", "name": "Bitcoin"},
+		},
+	}
+	price := map[string]map[string]float64{
+		"bitcoin": {"usd": 64000.0, "usd_24h_change": 0.5},
+	}
+	srv := newCoinGeckoServer(t, search, price)
+	defer srv.Close()
+
+	app := newCryptoApp(t, srv.URL)
+	cmd, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gbs"`)
+	if !strings.HasPrefix(cmd, "@pemit #1234=") {
+		t.Errorf("expected usage hint without a watchlist, got %q", cmd)
+	}
+
+	app.prefs.set("#1234", "stocks", "c:btc")
+	cmd, _ = app.checkLineForRegexps(`[Dino(#1234)] Dino says "gbs"`)
+	if !strings.HasPrefix(cmd, "pose S> BTC(Bitcoin)") {
+		t.Errorf("expected watchlist quote, got %q", cmd)
+	}
+}
+
+func TestCheckLine_WeatherNeedsLocation(t *testing.T) {
+	app := newTestApp()
+	cmd, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot weather"`)
+	if !strings.HasPrefix(cmd, "@pemit #1234=Gravybot: no location given") {
+		t.Errorf("expected usage hint, got %q", cmd)
+	}
+}
+
+func TestSavedLocationIsOnePlace(t *testing.T) {
+	var queries []string
+	srv := newZoneServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.prefs.set("#99", "location", "Denver, CO")
+
+	for _, cmd := range []string{"weather", "forecast", "aqi", "weather on yesterday"} {
+		queries = nil
+		got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot ` + cmd + `"`)
+		if strings.Count(got, "pose ") != 1 || len(queries) != 1 || !strings.HasSuffix(queries[0], " Denver, CO") {
+			t.Errorf("%s: %q after %q", cmd, got, queries)
+		}
+	}
+
+	// A list typed with the command is still several places.
+	queries = nil
+	app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather Denver, Boulder"`)
+	if len(queries) != 2 {
+		t.Errorf("typed list looked up as %q", queries)
+	}
+}
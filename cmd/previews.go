@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// previewProvider produces a site-specific preview for the links it handles.
+// Providers are tried in order by hostname; when the matching provider fails
+// the generic title extractor is used instead.
+type previewProvider interface {
+	matches(u *url.URL) bool
+	preview(tf *titleFetcher, u *url.URL) (pageInfo, error)
+}
+
+func defaultPreviewProviders() []previewProvider {
+	return []previewProvider{
+		&videoPreview{},
+		&repoPreview{apiBase: "https://api.github.com"},
+		&wikiPreview{},
+	}
+}
+
+// hostIs reports whether host is domain or one of its subdomains.
+func hostIs(host, domain string) bool {
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// renderPreview executes a provider's output template. Values are escaped for
+// the MUSH later, when the announcement is built.
+func renderPreview(tmpl *template.Template, data interface{}) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, data); err != nil {
+		return "", err
+	}
+	return cleanText(b.String(), 200), nil
+}
+
+// ── video ────────────────────────────────────────────────────────────────────
+
+var videoTemplate = template.Must(template.New("video").Parse(
+	`{{.Title}}{{if .Duration}} [{{.Duration}}]{{end}}{{if .Channel}} by {{.Channel}}{{end}}`))
+
+var (
+	videoDurationRe = regexp.MustCompile(`itemprop="duration"\s+content="(PT[0-9HMS]+)"`)
+	videoChannelRe  = regexp.MustCompile(`"ownerChannelName":"((?:[^"\\]|\\.)*)"`)
+	isoDurationRe   = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?$`)
+)
+
+// videoPreview reads the title, duration and channel from a YouTube watch
+// page. pageBase, when set, replaces the scheme and host of fetched URLs.
+type videoPreview struct {
+	pageBase string
+}
+
+func (p *videoPreview) matches(u *url.URL) bool {
+	return hostIs(u.Hostname(), "youtube.com") || hostIs(u.Hostname(), "youtu.be")
+}
+
+func (p *videoPreview) preview(tf *titleFetcher, u *url.URL) (pageInfo, error) {
+	page := u.String()
+	if hostIs(u.Hostname(), "youtu.be") {
+		page = "https://www.youtube.com/watch?v=" + strings.Trim(u.Path, "/")
+	}
+	if p.pageBase != "" {
+		pu, _ := url.Parse(page)
+		page = p.pageBase + pu.RequestURI()
+	}
+
+	body, err := tf.getBody(page, "text/html", "text/html")
+	if err != nil {
+		return pageInfo{}, err
+	}
+	doc := string(body)
+	info := extractPageInfo(doc)
+
+	data := struct{ Title, Duration, Channel string }{Title: info.Title}
+	if m := videoDurationRe.FindStringSubmatch(doc); m != nil {
+		data.Duration = formatISODuration(m[1])
+	}
+	if m := videoChannelRe.FindStringSubmatch(doc); m != nil {
+		var name string
+		if json.Unmarshal([]byte(`"`+m[1]+`"`), &name) == nil {
+			data.Channel = name
+		}
+	}
+	info.Summary, err = renderPreview(videoTemplate, data)
+	return info, err
+}
+
+// formatISODuration turns "PT1H2M3S" into "1:02:03" and "PT4M5S" into "4:05".
+func formatISODuration(s string) string {
+	m := isoDurationRe.FindStringSubmatch(s)
+	if m == nil {
+		return ""
+	}
+	hours, _ := strconv.Atoi(m[1])
+	mins, _ := strconv.Atoi(m[2])
+	secs, _ := strconv.Atoi(m[3])
+	mins += secs / 60
+	secs %= 60
+	hours += mins / 60
+	mins %= 60
+	if hours > 0 {
+		return fmt.Sprintf("%d:%02d:%02d", hours, mins, secs)
+	}
+	return fmt.Sprintf("%d:%02d", mins, secs)
+}
+
+// ── code repository ──────────────────────────────────────────────────────────
+
+var repoTemplate = template.Must(template.New("repo").Parse(
+	`{{.FullName}}{{if .Description}}: {{.Description}}{{end}} ({{.Stars}} stars{{if .Language}}, {{.Language}}{{end}})`))
+
+// repoPreview describes GitHub repositories using the repos API.
+type repoPreview struct {
+	apiBase string
+}
+
+func (p *repoPreview) matches(u *url.URL) bool {
+	return strings.EqualFold(u.Hostname(), "github.com") || strings.EqualFold(u.Hostname(), "www.github.com")
+}
+
+func (p *repoPreview) preview(tf *titleFetcher, u *url.URL) (pageInfo, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+		return pageInfo{}, fmt.Errorf("not a repository URL")
+	}
+	owner, name := parts[0], strings.TrimSuffix(parts[1], ".git")
+
+	body, err := tf.getBody(p.apiBase+"/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(name),
+		"application/vnd.github+json", "application/json")
+	if err != nil {
+		return pageInfo{}, err
+	}
+	var repo struct {
+		FullName    string `json:"full_name"`
+		Description string `json:"description"`
+		Stars       int    `json:"stargazers_count"`
+		Language    string `json:"language"`
+	}
+	if err := json.Unmarshal(body, &repo); err != nil {
+		return pageInfo{}, err
+	}
+	if repo.FullName == "" {
+		return pageInfo{}, fmt.Errorf("empty repository response")
+	}
+
+	data := struct {
+		FullName, Description, Stars, Language string
+	}{repo.FullName, repo.Description, formatWithCommas(repo.Stars), repo.Language}
+	summary, err := renderPreview(repoTemplate, data)
+	return pageInfo{Title: repo.FullName, Description: repo.Description, Summary: summary}, err
+}
+
+// ── encyclopedia ─────────────────────────────────────────────────────────────
+
+var wikiTemplate = template.Must(template.New("wiki").Parse(
+	`{{.Title}}: {{.Sentence}}`))
+
+var firstSentenceRe = regexp.MustCompile(`^.*?[.!?](?:\s|$)`)
+
+// wikiPreview gives the first sentence of a Wikipedia article via the REST
+// summary endpoint. apiBase, when set, replaces https://<lang>.wikipedia.org.
+type wikiPreview struct {
+	apiBase string
+}
+
+func (p *wikiPreview) matches(u *url.URL) bool {
+	return hostIs(u.Hostname(), "wikipedia.org") && strings.HasPrefix(u.Path, "/wiki/")
+}
+
+func (p *wikiPreview) preview(tf *titleFetcher, u *url.URL) (pageInfo, error) {
+	article := strings.TrimPrefix(u.EscapedPath(), "/wiki/")
+	base := p.apiBase
+	if base == "" {
+		lang := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".wikipedia.org")
+		lang = strings.TrimSuffix(lang, ".m")
+		if lang == "" || lang == "wikipedia.org" || lang == "www" {
+			lang = "en"
+		}
+		base = "https://" + lang + ".wikipedia.org"
+	}
+
+	body, err := tf.getBody(base+"/api/rest_v1/page/summary/"+article, "application/json", "application/json")
+	if err != nil {
+		return pageInfo{}, err
+	}
+	var summary struct {
+		Title   string `json:"title"`
+		Extract string `json:"extract"`
+	}
+	if err := json.Unmarshal(body, &summary); err != nil {
+		return pageInfo{}, err
+	}
+	if summary.Title == "" {
+		return pageInfo{}, fmt.Errorf("empty summary response")
+	}
+
+	sentence := strings.TrimSpace(firstSentenceRe.FindString(summary.Extract))
+	if sentence == "" {
+		sentence = summary.Extract
+	}
+	data := struct{ Title, Sentence string }{summary.Title, sentence}
+	text, err := renderPreview(wikiTemplate, data)
+	return pageInfo{Title: summary.Title, Description: sentence, Summary: text}, err
+}
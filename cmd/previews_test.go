@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestFormatISODuration(t *testing.T) {
+	cases := map[string]string{
+		"PT4M13S":  "4:13",
+		"PT1H2M3S": "1:02:03",
+		"PT45S":    "0:45",
+		"PT2H":     "2:00:00",
+		"PT90M":    "1:30:00",
+		"PT0M75S":  "1:15",
+		"P1D":      "",
+		"garbage":  "",
+	}
+	for in, want := range cases {
+		if got := formatISODuration(in); got != want {
+			t.Errorf("formatISODuration(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestPreviewProviders_Matching(t *testing.T) {
+	cases := []struct {
+		raw  string
+		want string // type name of the provider, "" for none
+	}{
+		{"https://www.youtube.com/watch?v=abc", "*main.videoPreview"},
+		{"https://youtu.be/abc", "*main.videoPreview"},
+		{"https://m.youtube.com/watch?v=abc", "*main.videoPreview"},
+		{"https://github.com/mjd/Xephyr", "*main.repoPreview"},
+		{"https://en.wikipedia.org/wiki/Dinosaur", "*main.wikiPreview"},
+		{"https://en.wikipedia.org/w/index.php?title=Dinosaur", ""},
+		{"https://notyoutube.com/watch", ""},
+		{"https://example.com/", ""},
+	}
+	for _, tc := range cases {
+		u, _ := url.Parse(tc.raw)
+		got := ""
+		for _, p := range defaultPreviewProviders() {
+			if p.matches(u) {
+				got = fmt.Sprintf("%T", p)
+				break
+			}
+		}
+		if got != tc.want {
+			t.Errorf("%s matched %q, want %q", tc.raw, got, tc.want)
+		}
+	}
+}
+
+func TestVideoPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/watch" || r.URL.Query().Get("v") != "abc123" {
+			t.Errorf("unexpected video request: %s", r.URL)
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<meta property="og:title" content="Dinosaur Facts">
+			<meta itemprop="duration" content="PT12M5S">
+			<script>var x = {"ownerChannelName":"Mesozoic \"Records\""};</script>`)
+	}))
+	defer srv.Close()
+
+	tf := newTestTitleFetcher()
+	tf.providers = []previewProvider{&videoPreview{pageBase: srv.URL}}
+	got := fetchURL(t, tf, "https://youtu.be/abc123")
+	if got.Summary != `Dinosaur Facts [12:05] by Mesozoic "Records"` {
+		t.Errorf("video summary = %q", got.Summary)
+	}
+	if got.Title != "Dinosaur Facts" {
+		t.Errorf("video title = %q", got.Title)
+	}
+}
+
+func TestRepoPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/mjd/Xephyr" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"full_name":"mjd/Xephyr","description":"A MUSH bot","stargazers_count":1234,"language":"Go"}`)
+	}))
+	defer srv.Close()
+
+	tf := newTestTitleFetcher()
+	tf.providers = []previewProvider{&repoPreview{apiBase: srv.URL}}
+	got := fetchURL(t, tf, "https://github.com/mjd/Xephyr/blob/main/cmd/main.go")
+	if got.Summary != "mjd/Xephyr: A MUSH bot (1,234 stars, Go)" {
+		t.Errorf("repo summary = %q", got.Summary)
+	}
+}
+
+func TestWikiPreview(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/rest_v1/page/summary/Tyrannosaurus" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		fmt.Fprint(w, `{"title":"Tyrannosaurus","extract":"Tyrannosaurus is a genus of large theropod dinosaur. It lived 68 million years ago."}`)
+	}))
+	defer srv.Close()
+
+	tf := newTestTitleFetcher()
+	tf.providers = []previewProvider{&wikiPreview{apiBase: srv.URL}}
+	got := fetchURL(t, tf, "https://en.wikipedia.org/wiki/Tyrannosaurus")
+	if got.Summary != "Tyrannosaurus: Tyrannosaurus is a genus of large theropod dinosaur." {
+		t.Errorf("wiki summary = %q", got.Summary)
+	}
+}
+
+// failingPreview matches every URL and always fails, to exercise the
+// fallback to the generic extractor.
+type failingPreview struct{}
+
+func (failingPreview) matches(u *url.URL) bool { return true }
+func (failingPreview) preview(tf *titleFetcher, u *url.URL) (pageInfo, error) {
+	return pageInfo{}, errors.New("provider down")
+}
+
+func TestPreviewFallsBackToGenericTitle(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Generic Title</title>`)
+	}))
+	defer srv.Close()
+
+	tf := newTestTitleFetcher()
+	tf.providers = []previewProvider{failingPreview{}}
+	got := fetchURL(t, tf, srv.URL)
+	if got.Title != "Generic Title" || got.Summary != "" {
+		t.Errorf("fallback result = %+v", got)
+	}
+	if a := got.announcement("https://y.rp/a"); !strings.Contains(a, `"Generic Title"`) {
+		t.Errorf("fallback announcement = %q", a)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// roomLocationsBucket maps MUSH room dbrefs to the real places they are
+// themed after, for "gravybot weather here".
+const roomLocationsBucket = "room_locations"
+
+// hereTimeout is how long a "weather here" waits for the game to say where
+// the speaker is.
+const hereTimeout = time.Minute
+
+type roomLocation struct {
+	Room  string    `json:"room"`
+	Place string    `json:"place"`
+	SetBy string    `json:"set_by"`
+	Set   time.Time `json:"set"`
+}
+
+// hereRequest is a "weather here", waiting on a XEPHYR-WHERE reply when
+// asked is set. A non-zero date asks about that past day.
+type hereRequest struct {
+	units   string
+	verbose bool
+	date    time.Time
+	asked   time.Time
+}
+
+var (
+	// roomRe matches the line the bot's @Amove/@Startup softcode pemits to
+	// tell us where it is.
+	roomRe    = regexp.MustCompile(`^XEPHYR-ROOM: (#\d+) ?(.*)$`)
+	channelRe = regexp.MustCompile(`^<([^>]+)> `)
+	// whereRe matches the answer to the loc() query sent by askWhere.
+	whereRe = regexp.MustCompile(`^XEPHYR-WHERE: (#\d+) (#-?\d+)$`)
+	dbrefRe = regexp.MustCompile(`^#\d+$`)
+)
+
+// botState is what the bot knows about its own situation in the game. It is
+// shared by pointer because the telnet caller holds a copy of application.
+type botState struct {
+	mu       sync.Mutex
+	room     string
+	roomName string
+	asked    map[string]hereRequest // "weather here" awaiting XEPHYR-WHERE, by dbref
+	ring     *urlRing               // the game's URL ring while it is read back
+	send     sync.Mutex             // one writer to the game at a time
+}
+
+func (bs *botState) setRoom(dbref, name string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.room, bs.roomName = dbref, name
+}
+
+func (bs *botState) currentRoom() string {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.room
+}
+
+// whereFrom works out where a line was said: the channel named at the start
+// of the text, or otherwise the room the bot is standing in.
+func (app *application) whereFrom(rest string) string {
+	if m := channelRe.FindStringSubmatch(rest); m != nil {
+		return "channel:" + m[1]
+	}
+	return app.state.currentRoom()
+}
+
+func (app *application) roomLocations() repo[roomLocation] {
+	return repo[roomLocation]{st: app.store, bucket: roomLocationsBucket}
+}
+
+// weatherHere reports the weather for the real place mapped to room. When
+// the speaker's room isn't known, because they spoke on a channel or the bot
+// hasn't been told where it is, the game is asked with a framed loc() query
+// and the answer comes back through answerWhere.
+func (app *application) weatherHere(userID, room string, req hereRequest) string {
+	if !dbrefRe.MatchString(room) {
+		return app.askWhere(userID, req)
+	}
+	rl, ok, err := app.roomLocations().get(room)
+	if err != nil {
+		app.errorLog.Printf("room location %s: %s", room, err)
+	}
+	if !ok {
+		return "@pemit " + userID + "=Gravybot: this room isn't mapped to a real place. Try: gravybot weather <location>\n"
+	}
+	loc := parseLatLon(app.resolveLocation(rl.Place))
+	var response string
+	if req.date.IsZero() {
+		response, err = app.sendWeatherRequest(loc, req.units, req.verbose)
+	} else {
+		response, err = app.sendHistoryRequest(loc, req.date, req.units)
+	}
+	if err != nil {
+		response = "Error: weather api call failed.\n"
+	}
+	return "pose W> " + response
+}
+
+// pageRe matches the ways a page arrives: "Rex pages: hi", "Rex pages you:
+// hi" and the posed "From afar, Rex waves."
+var pageRe = regexp.MustCompile(`^(?:\S+ pages\b|From afar, )`)
+
+// sameRoom reports whether rest, a line after its nospoof tag, is name
+// saying or posing something where the bot is. Pages, emits from elsewhere
+// and channel chatter say nothing about where the speaker stands.
+func sameRoom(name, rest string) bool {
+	if pageRe.MatchString(rest) || channelRe.MatchString(rest) {
+		return false
+	}
+	return strings.HasPrefix(rest, name+" ") || strings.HasPrefix(rest, name+"'")
+}
+
+func (app *application) askWhere(userID string, req hereRequest) string {
+	app.state.mu.Lock()
+	defer app.state.mu.Unlock()
+	if app.state.asked == nil {
+		app.state.asked = map[string]hereRequest{}
+	}
+	req.asked = time.Now()
+	app.state.asked[userID] = req
+	return fmt.Sprintf("@pemit me=XEPHYR-WHERE: %s [loc(%s)]\n", userID, userID)
+}
+
+// answerWhere finishes the "weather here" waiting on userID, if any.
+func (app *application) answerWhere(userID, room string, now time.Time) string {
+	app.state.mu.Lock()
+	req, ok := app.state.asked[userID]
+	delete(app.state.asked, userID)
+	app.state.mu.Unlock()
+	if !ok || now.Sub(req.asked) > hereTimeout {
+		return ""
+	}
+	if room == "#-1" {
+		return "@pemit " + userID + "=Gravybot: I can't tell where you are.\n"
+	}
+	return app.weatherHere(userID, room, req)
+}
+
+// handleRoomLocation implements the admin-only "gravybot location map
+// <#room|here> <place>", "unmap <#room|here>" and "rooms".
+func (app *application) handleRoomLocation(userID, verb string, args []string) string {
+	if !app.isAdmin(userID) {
+		return "@pemit " + userID + "=Gravybot: only admins can map rooms to places.\n"
+	}
+	if verb == "rooms" {
+		return app.listRoomLocations(userID)
+	}
+	if len(args) == 0 || (verb == "map" && len(args) < 2) {
+		return "@pemit " + userID + "=Gravybot: try gravybot location map <#room|here> <place>|unmap <#room|here>\n"
+	}
+	room := args[0]
+	if strings.EqualFold(room, "here") {
+		room = app.state.currentRoom()
+	}
+	if !dbrefRe.MatchString(room) {
+		return "@pemit " + userID + "=Gravybot: " + mushEscape(args[0]) + " isn't a room I know.\n"
+	}
+
+	r := app.roomLocations()
+	if verb == "unmap" {
+		if err := r.delete(room); err != nil {
+			app.errorLog.Printf("room location %s: %s", room, err)
+			return "@pemit " + userID + "=Gravybot: couldn't unmap that room.\n"
+		}
+		return "@pemit " + userID + "=Gravybot: " + room + " is no longer mapped.\n"
+	}
+	place := strings.Join(args[1:], " ")
+	rl := roomLocation{Room: room, Place: place, SetBy: userID, Set: time.Now().UTC()}
+	if err := r.put(room, rl); err != nil {
+		app.errorLog.Printf("room location %s: %s", room, err)
+		return "@pemit " + userID + "=Gravybot: couldn't map that room.\n"
+	}
+	return "@pemit " + userID + "=Gravybot: " + room + " is now " + mushEscape(place) + ".\n"
+}
+
+func (app *application) listRoomLocations(userID string) string {
+	all, err := app.roomLocations().all()
+	if err != nil {
+		app.errorLog.Printf("room locations: %s", err)
+		return "@pemit " + userID + "=Gravybot: couldn't read the room map.\n"
+	}
+	if len(all) == 0 {
+		return "@pemit " + userID + "=Gravybot: no rooms are mapped yet.\n"
+	}
+	rooms := make([]string, 0, len(all))
+	for room := range all {
+		rooms = append(rooms, room)
+	}
+	sort.Strings(rooms)
+	entries := make([]string, 0, len(rooms))
+	for _, room := range rooms {
+		entries = append(entries, room+" ("+all[room].Place+")")
+	}
+	return "@pemit " + userID + "=Gravybot: mapped rooms: " + mushEscape(strings.Join(entries, ", ")) + "\n"
+}
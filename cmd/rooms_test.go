@@ -0,0 +1,104 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleRoomLocation(t *testing.T) {
+	app := newTestApp()
+	app.config.admins = "#1"
+	app.state.setRoom("#500", "Hangout")
+
+	if got := app.handleLocation("#42", "map here Denver"); !strings.Contains(got, "only admins") {
+		t.Errorf("non-admin: %q", got)
+	}
+	if got := app.handleLocation("#1", "map here Denver CO"); got != "@pemit #1=Gravybot: #500 is now Denver CO.\n" {
+		t.Errorf("map here: %q", got)
+	}
+	app.handleLocation("#1", "map #77 dino")
+	if got := app.handleLocation("#1", "rooms"); got != "@pemit #1=Gravybot: mapped rooms: #500 (Denver CO), #77 (dino)\n" {
+		t.Errorf("rooms: %q", got)
+	}
+	if got := app.handleLocation("#1", "map lobby Paris"); !strings.Contains(got, "isn't a room I know") {
+		t.Errorf("bad room: %q", got)
+	}
+	if got := app.handleLocation("#1", "map #77"); !strings.Contains(got, "try gravybot location map") {
+		t.Errorf("missing place: %q", got)
+	}
+	if got := app.handleLocation("#1", "unmap #77"); got != "@pemit #1=Gravybot: #77 is no longer mapped.\n" {
+		t.Errorf("unmap: %q", got)
+	}
+}
+
+func TestWeatherHere_InRoom(t *testing.T) {
+	var queries []string
+	srv := newZoneServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.config.admins = "#1"
+
+	app.checkLineForRegexps("XEPHYR-ROOM: #500 Hangout")
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather here"`)
+	if !strings.Contains(got, "isn't mapped") || len(queries) != 0 {
+		t.Errorf("unmapped room: %q %q", got, queries)
+	}
+
+	app.handleLocation("#42", "add dino 39.7,-104.9")
+	app.handleLocation("#1", "map #500 dino")
+	got, _ = app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather -c HERE"`)
+	if !strings.HasPrefix(got, "pose W> Denver, Colorado: Sunny") || strings.Join(queries, "|") != "/current.json 39.7,-104.9" {
+		t.Errorf("mapped room: %q %q", got, queries)
+	}
+}
+
+func TestWeatherHere_AsksTheGame(t *testing.T) {
+	var queries []string
+	srv := newZoneServer(t, &queries)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.config.admins = "#1"
+	app.handleLocation("#1", "map #600 Denver")
+
+	// On a channel the speaker could be anywhere, so the game is asked.
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] <Public> Rex says "gravybot weather --verbose here"`)
+	if got != "@pemit me=XEPHYR-WHERE: #99 [loc(#99)]\n" {
+		t.Fatalf("query: %q", got)
+	}
+	got, _ = app.checkLineForRegexps("XEPHYR-WHERE: #99 #600")
+	if !strings.HasPrefix(got, "pose W> Denver, Colorado: Sunny") || !strings.Contains(got, "feels like") {
+		t.Errorf("answer: %q", got)
+	}
+	// Each answer is used once.
+	if got, _ := app.checkLineForRegexps("XEPHYR-WHERE: #99 #600"); got != "" {
+		t.Errorf("answer reused: %q", got)
+	}
+
+	// Nor can the bot's own room be used for pages or emits from elsewhere.
+	app.checkLineForRegexps("XEPHYR-ROOM: #500 Hangout")
+	for _, line := range []string{
+		`[Rex(#99)] Rex pages: gravybot weather here`,
+		`[Rex(#99)] From afar, Rex asks gravybot weather here`,
+		`[Rex(#99)] A voice from the Tower calls: gravybot weather here`,
+	} {
+		if got, _ := app.checkLineForRegexps(line); got != "@pemit me=XEPHYR-WHERE: #99 [loc(#99)]\n" {
+			t.Errorf("%s\nanswered %q", line, got)
+		}
+		app.answerWhere("#99", "#600", time.Now())
+	}
+	if got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex asks gravybot weather here`); !strings.Contains(got, "isn't mapped") {
+		t.Errorf("pose in the bot's room: %q", got)
+	}
+
+	app.checkLineForRegexps(`[Rex(#99)] <Public> Rex says "gravybot weather here"`)
+	if got := app.answerWhere("#99", "#-1", time.Now()); !strings.Contains(got, "can't tell where you are") {
+		t.Errorf("hidden: %q", got)
+	}
+	app.checkLineForRegexps(`[Rex(#99)] <Public> Rex says "gravybot weather here"`)
+	if got := app.answerWhere("#99", "#600", time.Now().Add(2*hereTimeout)); got != "" {
+		t.Errorf("stale answer used: %q", got)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+// recentLinksShown is how many links the index page lists.
+const recentLinksShown = 50
+
+var recentLinksTmpl = template.Must(template.New("recent").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Bot}} links</title>
+<link rel="alternate" type="application/atom+xml" href="/feed.atom">
+<link rel="alternate" type="application/rss+xml" href="/feed.rss">
+</head>
+<body>
+<h1>Recent links</h1>
+{{if .Links}}<table>
+<tr><th>Link</th><th>Destination</th><th>Clicks</th><th>Created</th></tr>
+{{range .Links}}<tr><td><a href="{{$.Base}}/{{.Code}}">{{.Code}}</a></td><td>{{.Long}}</td><td>{{.Clicks}}</td><td>{{.Created.Format "2006-01-02 15:04"}}</td></tr>
+{{end}}</table>{{else}}<p>No links yet.</p>{{end}}
+</body>
+</html>
+`))
+
+// routes is the bot's HTTP interface: short-link redirects, a read-only
+// list of recent links, and feeds of captured URLs.
+func (app *application) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/feed.atom", app.serveAtom)
+	mux.HandleFunc("/feed.rss", app.serveRSS)
+	mux.HandleFunc("/", app.serveLink)
+	return mux
+}
+
+// serveLink answers "/" with the recent links page and "/<code>" with a 302
+// to the code's destination, counting the click.
+func (app *application) serveLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	code := strings.TrimPrefix(r.URL.Path, "/")
+	if code == "" {
+		app.serveRecentLinks(w, r)
+		return
+	}
+	if strings.Trim(code, codeAlphabet) != "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	lookup := app.links.click
+	if r.Method == http.MethodHead {
+		lookup = app.links.get
+	}
+	link, ok, err := lookup(code)
+	if err != nil {
+		app.errorLog.Printf("link %s: %s", code, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	http.Redirect(w, r, link.Long, http.StatusFound)
+}
+
+// linkBase is the link server's public URL from -localbase. Without it the
+// links the server emits are relative; the request's Host header is never
+// used, as any client can set it.
+func (app *application) linkBase() string {
+	return strings.TrimRight(app.config.localBaseURL, "/")
+}
+
+func (app *application) serveRecentLinks(w http.ResponseWriter, r *http.Request) {
+	links, err := app.links.recent(recentLinksShown)
+	if err != nil {
+		app.errorLog.Printf("recent links: %s", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Bot   string
+		Base  string
+		Links []shortLink
+	}{app.config.botName, app.linkBase(), links}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := recentLinksTmpl.Execute(w, data); err != nil {
+		app.errorLog.Printf("recent links page: %s", err)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newLinkServer starts the bot's HTTP routes with links already issued for
+// each of longs, returning the server and the issued codes.
+func newLinkServer(t *testing.T, longs ...string) (*httptest.Server, *application, []string) {
+	t.Helper()
+	app := newTestApp()
+	app.config.localBaseURL = "https://go.example"
+	var codes []string
+	for _, l := range longs {
+		link, err := app.links.issue(l)
+		if err != nil {
+			t.Fatalf("issue: %v", err)
+		}
+		codes = append(codes, link.Code)
+	}
+	srv := httptest.NewServer(app.routes())
+	t.Cleanup(srv.Close)
+	return srv, app, codes
+}
+
+// noFollow is a client that reports redirects instead of following them.
+var noFollow = &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}}
+
+func TestServeLink_RedirectsAndCounts(t *testing.T) {
+	srv, app, codes := newLinkServer(t, "https://example.com/a")
+
+	for i := 0; i < 2; i++ {
+		res, err := noFollow.Get(srv.URL + "/" + codes[0])
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "https://example.com/a" {
+			t.Fatalf("got %d to %q", res.StatusCode, res.Header.Get("Location"))
+		}
+	}
+	res, err := noFollow.Head(srv.URL + "/" + codes[0])
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("head: %v %v", res, err)
+	}
+
+	link, _, _ := app.links.get(codes[0])
+	if link.Clicks != 2 {
+		t.Errorf("clicks = %d, want 2 (HEAD not counted)", link.Clicks)
+	}
+}
+
+func TestLinkStore_FlushesClicksInOneWrite(t *testing.T) {
+	_, app, codes := newLinkServer(t, "https://example.com/a", "https://example.com/b")
+	stored := repo[shortLink]{st: app.store, bucket: linksBucket}
+
+	app.links.click(codes[0])
+	app.links.click(codes[0])
+	app.links.click(codes[1])
+	if link, _, _ := stored.get(codes[0]); link.Clicks != 0 {
+		t.Errorf("clicks written before flush: %d", link.Clicks)
+	}
+	if recent, _ := app.links.recent(10); len(recent) != 2 || recent[0].Clicks+recent[1].Clicks != 3 {
+		t.Errorf("recent = %+v, want pending clicks counted", recent)
+	}
+
+	if err := app.links.flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	a, _, _ := stored.get(codes[0])
+	b, _, _ := stored.get(codes[1])
+	if a.Clicks != 2 || b.Clicks != 1 {
+		t.Errorf("stored clicks %d, %d; want 2, 1", a.Clicks, b.Clicks)
+	}
+	if link, _, _ := app.links.get(codes[0]); link.Clicks != 2 {
+		t.Errorf("clicks = %d after flush, want 2", link.Clicks)
+	}
+}
+
+func TestServeLink_Errors(t *testing.T) {
+	srv, _, _ := newLinkServer(t)
+
+	for _, path := range []string{"/nosuch", "/bad-code!", "/a/b"} {
+		res, err := noFollow.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != http.StatusNotFound {
+			t.Errorf("%s: status %d, want 404", path, res.StatusCode)
+		}
+	}
+
+	res, err := http.Post(srv.URL+"/", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("post: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST status %d, want 405", res.StatusCode)
+	}
+}
+
+func TestServeRecentLinks(t *testing.T) {
+	srv, _, codes := newLinkServer(t, "https://example.com/a", "https://example.com/<script>")
+
+	res, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	page := string(body)
+
+	for _, c := range codes {
+		if !strings.Contains(page, `href="https://go.example/`+c+`"`) {
+			t.Errorf("page missing link for %s:\n%s", c, page)
+		}
+	}
+	if strings.Contains(page, "<script>") {
+		t.Errorf("destination not escaped:\n%s", page)
+	}
+}
+
+func TestServeRecentLinks_RelativeWithoutLocalBase(t *testing.T) {
+	srv, app, codes := newLinkServer(t, "https://example.com/a")
+	app.config.localBaseURL = ""
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/", nil)
+	req.Host = "evil.example"
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	page := string(body)
+	if !strings.Contains(page, `href="/`+codes[0]+`"`) || strings.Contains(page, "evil.example") {
+		t.Errorf("links should be relative and ignore Host:\n%s", page)
+	}
+}
+
+func TestServeRecentLinks_Empty(t *testing.T) {
+	srv, _, _ := newLinkServer(t)
+	res, err := http.Get(srv.URL + "/")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+	if !strings.Contains(string(body), "No links yet.") {
+		t.Errorf("unexpected page:\n%s", body)
+	}
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Shortener turns a long URL into a short one.
+type Shortener interface {
+	Name() string
+	Shorten(longURL string) (string, error)
+}
+
+// shortenerChain tries each shortener in order until one succeeds.
+type shortenerChain []Shortener
+
+// shorten returns the first short URL produced and the name of the shortener
+// that made it, or every shortener's error if they all failed.
+func (c shortenerChain) shorten(longURL string) (string, string, error) {
+	var errs []error
+	for _, s := range c {
+		short, err := s.Shorten(longURL)
+		if err == nil && short != "" {
+			return short, s.Name(), nil
+		}
+		if err == nil {
+			err = errors.New("empty short URL")
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", s.Name(), err))
+	}
+	if len(errs) == 0 {
+		return "", "", errors.New("no shorteners configured")
+	}
+	return "", "", errors.Join(errs...)
+}
+
+// buildShorteners assembles the chain named by config.shorteners.
+func (app *application) buildShorteners() (shortenerChain, error) {
+	var chain shortenerChain
+	for _, name := range strings.Split(app.config.shorteners, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "yirp":
+			chain = append(chain, &yirpShortener{app: app})
+		case "rest":
+			if app.config.restShortenerURL == "" {
+				return nil, errors.New("rest shortener needs -restshortener")
+			}
+			chain = append(chain, &restShortener{
+				endpoint:    app.config.restShortenerURL,
+				field:       app.config.restShortenerField,
+				resultField: app.config.restShortenerResult,
+				authHeader:  app.config.restShortenerAuth,
+				client:      &http.Client{Timeout: 10 * time.Second},
+			})
+		case "local":
+			if app.config.localBaseURL == "" {
+				return nil, errors.New("local shortener needs -localbase, the public URL of the link server")
+			}
+			chain = append(chain, &localShortener{links: app.links, base: app.config.localBaseURL})
+		default:
+			return nil, fmt.Errorf("unknown shortener %q", name)
+		}
+	}
+	return chain, nil
+}
+
+// yirpShortener uses the Yirp API via sendUrlToYirp.
+type yirpShortener struct {
+	app *application
+}
+
+func (y *yirpShortener) Name() string { return "yirp" }
+
+func (y *yirpShortener) Shorten(longURL string) (string, error) {
+	return y.app.sendUrlToYirp(longURL)
+}
+
+// restShortener posts {"<field>": longURL} to a JSON API and reads the short
+// URL from resultField, which may be a dotted path such as "data.link".
+// authHeader, if set, is sent as-is, e.g. "Authorization: Bearer <token>".
+type restShortener struct {
+	endpoint    string
+	field       string
+	resultField string
+	authHeader  string
+	client      *http.Client
+}
+
+func (r *restShortener) Name() string { return "rest" }
+
+func (r *restShortener) Shorten(longURL string) (string, error) {
+	body, err := json.Marshal(map[string]string{r.field: longURL})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", r.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if name, value, ok := strings.Cut(r.authHeader, ":"); ok {
+		req.Header.Set(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	res, err := r.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return "", fmt.Errorf("API returned code %d", res.StatusCode)
+	}
+
+	var result interface{}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", err
+	}
+	for _, key := range strings.Split(r.resultField, ".") {
+		obj, ok := result.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("no %q in response", r.resultField)
+		}
+		result = obj[key]
+	}
+	short, ok := result.(string)
+	if !ok || short == "" {
+		return "", fmt.Errorf("no %q in response", r.resultField)
+	}
+	return short, nil
+}
+
+const (
+	linksBucket      = "links"
+	linksByURLBucket = "links_by_url"
+)
+
+// shortLink is a short code issued by the local shortener.
+type shortLink struct {
+	Code    string    `json:"code"`
+	Long    string    `json:"long"`
+	Created time.Time `json:"created"`
+	Clicks  int64     `json:"clicks"`
+}
+
+// clickFlushEvery is how often counted clicks are written to the store.
+const clickFlushEvery = time.Minute
+
+// linkStore keeps local short codes in the links bucket, with a reverse
+// index so the same long URL always gets the same code. Clicks are counted
+// in memory and written by flush, so a visit doesn't rewrite the store.
+type linkStore struct {
+	st *store
+
+	mu      sync.Mutex
+	pending map[string]int64
+}
+
+func newLinkStore(st *store) *linkStore {
+	return &linkStore{st: st, pending: map[string]int64{}}
+}
+
+const codeAlphabet = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// issue returns the code for longURL, creating one if needed.
+func (ls *linkStore) issue(longURL string) (shortLink, error) {
+	var link shortLink
+	err := ls.st.update(func(tx *storeTx) error {
+		var code string
+		if ok, err := tx.get(linksByURLBucket, longURL, &code); err != nil || ok {
+			if err != nil {
+				return err
+			}
+			_, err := tx.get(linksBucket, code, &link)
+			return err
+		}
+		for {
+			code = ""
+			for i := 0; i < 6; i++ {
+				n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+				if err != nil {
+					return err
+				}
+				code += string(codeAlphabet[n.Int64()])
+			}
+			if _, ok := tx.raw(linksBucket, code); !ok {
+				break
+			}
+		}
+		link = shortLink{Code: code, Long: longURL, Created: time.Now().UTC()}
+		if err := tx.put(linksBucket, code, link); err != nil {
+			return err
+		}
+		return tx.put(linksByURLBucket, longURL, code)
+	})
+	return link, err
+}
+
+func (ls *linkStore) get(code string) (shortLink, bool, error) {
+	link, ok, err := repo[shortLink]{st: ls.st, bucket: linksBucket}.get(code)
+	ls.mu.Lock()
+	link.Clicks += ls.pending[code]
+	ls.mu.Unlock()
+	return link, ok, err
+}
+
+// click looks up code and counts a visit to it.
+func (ls *linkStore) click(code string) (shortLink, bool, error) {
+	link, ok, err := repo[shortLink]{st: ls.st, bucket: linksBucket}.get(code)
+	if err != nil || !ok {
+		return link, ok, err
+	}
+	ls.mu.Lock()
+	ls.pending[code]++
+	link.Clicks += ls.pending[code]
+	ls.mu.Unlock()
+	return link, true, nil
+}
+
+// flush writes the clicks counted since the last flush in one update.
+func (ls *linkStore) flush() error {
+	ls.mu.Lock()
+	pending := ls.pending
+	ls.pending = map[string]int64{}
+	ls.mu.Unlock()
+	if len(pending) == 0 {
+		return nil
+	}
+	err := ls.st.update(func(tx *storeTx) error {
+		for code, n := range pending {
+			var link shortLink
+			if ok, err := tx.get(linksBucket, code, &link); err != nil || !ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			link.Clicks += n
+			if err := tx.put(linksBucket, code, link); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// Keep the counts for the next try.
+		ls.mu.Lock()
+		for code, n := range pending {
+			ls.pending[code] += n
+		}
+		ls.mu.Unlock()
+	}
+	return err
+}
+
+// recent returns up to n links, newest first.
+func (ls *linkStore) recent(n int) ([]shortLink, error) {
+	all, err := repo[shortLink]{st: ls.st, bucket: linksBucket}.all()
+	if err != nil {
+		return nil, err
+	}
+	links := make([]shortLink, 0, len(all))
+	ls.mu.Lock()
+	for code, l := range all {
+		l.Clicks += ls.pending[code]
+		links = append(links, l)
+	}
+	ls.mu.Unlock()
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Created.Equal(links[j].Created) {
+			return links[i].Code < links[j].Code
+		}
+		return links[i].Created.After(links[j].Created)
+	})
+	if len(links) > n {
+		links = links[:n]
+	}
+	return links, nil
+}
+
+// localShortener issues codes from the store and builds short URLs under
+// base, which is where the bot's own link server answers.
+type localShortener struct {
+	links *linkStore
+	base  string
+}
+
+func (l *localShortener) Name() string { return "local" }
+
+func (l *localShortener) Shorten(longURL string) (string, error) {
+	link, err := l.links.issue(longURL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(l.base, "/") + "/" + link.Code, nil
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// stubShortener returns a fixed short URL or error.
+type stubShortener struct {
+	name  string
+	short string
+	err   error
+	calls int
+}
+
+func (s *stubShortener) Name() string { return s.name }
+
+func (s *stubShortener) Shorten(longURL string) (string, error) {
+	s.calls++
+	return s.short, s.err
+}
+
+func TestShortenerChain_FallsBackInOrder(t *testing.T) {
+	first := &stubShortener{name: "first", err: errors.New("quota exceeded")}
+	second := &stubShortener{name: "second", short: "https://s.example/b"}
+	third := &stubShortener{name: "third", short: "https://s.example/c"}
+
+	short, by, err := shortenerChain{first, second, third}.shorten("https://example.com/")
+	if err != nil {
+		t.Fatalf("shorten: %v", err)
+	}
+	if short != "https://s.example/b" || by != "second" {
+		t.Errorf("got %q from %q, want second's link", short, by)
+	}
+	if third.calls != 0 {
+		t.Errorf("third shortener called %d times after second succeeded", third.calls)
+	}
+}
+
+func TestShortenerChain_AllFail(t *testing.T) {
+	chain := shortenerChain{
+		&stubShortener{name: "a", err: errors.New("down")},
+		&stubShortener{name: "b"}, // empty result counts as failure
+	}
+	_, _, err := chain.shorten("https://example.com/")
+	if err == nil || !strings.Contains(err.Error(), "a: down") || !strings.Contains(err.Error(), "b: empty") {
+		t.Errorf("err = %v, want both failures reported", err)
+	}
+	if _, _, err := (shortenerChain{}).shorten("https://example.com/"); err == nil {
+		t.Error("empty chain should fail")
+	}
+}
+
+func TestBuildShorteners(t *testing.T) {
+	app := newTestApp()
+	app.config.shorteners = "yirp, local"
+	if _, err := app.buildShorteners(); err == nil {
+		t.Error("local without -localbase should be rejected")
+	}
+	app.config.localBaseURL = "https://go.example"
+	chain, err := app.buildShorteners()
+	if err != nil {
+		t.Fatalf("buildShorteners: %v", err)
+	}
+	if len(chain) != 2 || chain[0].Name() != "yirp" || chain[1].Name() != "local" {
+		t.Errorf("unexpected chain %v", chain)
+	}
+
+	app.config.shorteners = "rest"
+	if _, err := app.buildShorteners(); err == nil {
+		t.Error("rest without an endpoint should be rejected")
+	}
+	app.config.shorteners = "bitly"
+	if _, err := app.buildShorteners(); err == nil {
+		t.Error("unknown shortener should be rejected")
+	}
+}
+
+func TestRestShortener(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sekrit" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req map[string]string
+		json.NewDecoder(r.Body).Decode(&req)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"data": map[string]string{"link": "https://sho.rt/" + strings.TrimPrefix(req["long_url"], "https://")},
+		})
+	}))
+	defer srv.Close()
+
+	rs := &restShortener{endpoint: srv.URL, field: "long_url", resultField: "data.link",
+		authHeader: "Authorization: Bearer sekrit", client: srv.Client()}
+	short, err := rs.Shorten("https://example.com")
+	if err != nil || short != "https://sho.rt/example.com" {
+		t.Errorf("Shorten = %q, %v", short, err)
+	}
+
+	rs.authHeader = ""
+	if _, err := rs.Shorten("https://example.com"); err == nil {
+		t.Error("unauthorized request should fail")
+	}
+	rs.authHeader, rs.resultField = "Authorization: Bearer sekrit", "data.missing"
+	if _, err := rs.Shorten("https://example.com"); err == nil {
+		t.Error("missing result field should fail")
+	}
+}
+
+func TestLocalShortener_StableCodes(t *testing.T) {
+	st, _ := openStore("")
+	ls := &localShortener{links: newLinkStore(st), base: "https://go.example/"}
+
+	a, err := ls.Shorten("https://example.com/a")
+	if err != nil {
+		t.Fatalf("Shorten: %v", err)
+	}
+	if !strings.HasPrefix(a, "https://go.example/") || len(a) != len("https://go.example/")+6 {
+		t.Errorf("unexpected short URL %q", a)
+	}
+	again, _ := ls.Shorten("https://example.com/a")
+	if again != a {
+		t.Errorf("same URL got a new code: %q then %q", a, again)
+	}
+	b, _ := ls.Shorten("https://example.com/b")
+	if b == a {
+		t.Errorf("different URLs share code %q", a)
+	}
+}
+
+func TestProcessUrls_FallsBackToLocal(t *testing.T) {
+	app := newTestApp()
+	app.config.localBaseURL = "https://go.example"
+	app.shorteners = shortenerChain{
+		&stubShortener{name: "yirp", err: errors.New("down")},
+		&localShortener{links: newLinkStore(app.store), base: app.config.localBaseURL},
+	}
+
+	out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/page"})
+	if !strings.HasPrefix(out, "add_url #42 https://go.example/") {
+		t.Errorf("expected a local short link, got %q", out)
+	}
+}
+
+func TestProcessUrls_RecordUnshortened(t *testing.T) {
+	app := newTestApp()
+	app.shorteners = shortenerChain{&stubShortener{name: "yirp", err: errors.New("down")}}
+
+	out, _ := app.processUrls("#42", "Alice", "#100", []string{"https://example.com/page"})
+	if out != "" {
+		t.Errorf("failed shorten should be skipped by default, got %q", out)
+	}
+
+	app.config.recordUnshortened = true
+	out, _ = app.processUrls("#42", "Alice", "#100", []string{"https://example.com/page"})
+	if out != "add_url #42 https://example.com/page https://example.com/page\n@trigger me/TRIGGER_LAST_URL\n" {
+		t.Errorf("unexpected output %q", out)
+	}
+	recs, _ := app.urls.find(nil)
+	if len(recs) != 1 || recs[0].Short != "https://example.com/page" {
+		t.Errorf("long URL not recorded: %+v", recs)
+	}
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// storeFileName is the snapshot file kept in the data directory.
+const storeFileName = "xephyr.json"
+
+// store is the bot's embedded key/value database: named buckets of JSON
+// values held in memory and written out as a single snapshot after every
+// committed update. Snapshots are written to a temporary file, synced and
+// renamed into place, so a crash leaves either the old or the new data.
+type store struct {
+	mu      sync.RWMutex
+	dir     string
+	version int
+	buckets map[string]map[string]json.RawMessage
+}
+
+type storeFile struct {
+	Version int                                   `json:"version"`
+	Buckets map[string]map[string]json.RawMessage `json:"buckets"`
+}
+
+// migration upgrades the stored data by one schema version. Migrations run
+// in order inside a single update when the store is opened.
+type migration struct {
+	version int
+	name    string
+	apply   func(tx *storeTx, dir string) error
+}
+
+var migrations = []migration{
+	{1, "add normalized form to URL history", migrateURLNorm},
+}
+
+// schemaVersion is the version a freshly migrated store ends up at.
+func schemaVersion() int {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].version
+}
+
+// openStore loads the store from dir, creating it if needed, and applies any
+// pending migrations. An empty dir gives a memory-only store.
+func openStore(dir string) (*store, error) {
+	s := &store{dir: dir, buckets: map[string]map[string]json.RawMessage{}}
+	if dir != "" {
+		data, err := os.ReadFile(filepath.Join(dir, storeFileName))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if err == nil {
+			var f storeFile
+			if err := json.Unmarshal(data, &f); err != nil {
+				return nil, fmt.Errorf("parse %s: %w", storeFileName, err)
+			}
+			s.version = f.Version
+			if f.Buckets != nil {
+				s.buckets = f.Buckets
+			}
+		}
+	}
+
+	if s.version > schemaVersion() {
+		return nil, fmt.Errorf("store is at schema version %d but this build only knows %d", s.version, schemaVersion())
+	}
+	if s.version == schemaVersion() {
+		return s, nil
+	}
+	err := s.update(func(tx *storeTx) error {
+		for _, m := range migrations {
+			if m.version <= s.version {
+				continue
+			}
+			if err := m.apply(tx, dir); err != nil {
+				return fmt.Errorf("migration %d (%s): %w", m.version, m.name, err)
+			}
+			tx.version = m.version
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// storeTx collects the reads and writes of one view or update. Writes are
+// staged and only become visible to other callers when the update commits.
+type storeTx struct {
+	s        *store
+	writable bool
+	version  int
+	writes   map[string]map[string]json.RawMessage // nil value means delete
+}
+
+// view runs fn with read-only access to the store.
+func (s *store) view(fn func(tx *storeTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(&storeTx{s: s, version: s.version})
+}
+
+// update runs fn and commits its writes atomically. If fn or the snapshot
+// write fails, nothing is changed.
+func (s *store) update(fn func(tx *storeTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tx := &storeTx{s: s, writable: true, version: s.version, writes: map[string]map[string]json.RawMessage{}}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if len(tx.writes) == 0 && tx.version == s.version {
+		return nil
+	}
+
+	next := make(map[string]map[string]json.RawMessage, len(s.buckets))
+	for name, b := range s.buckets {
+		next[name] = b
+	}
+	for name, writes := range tx.writes {
+		b := make(map[string]json.RawMessage, len(next[name])+len(writes))
+		for k, v := range next[name] {
+			b[k] = v
+		}
+		for k, v := range writes {
+			if v == nil {
+				delete(b, k)
+			} else {
+				b[k] = v
+			}
+		}
+		next[name] = b
+	}
+
+	if err := s.persist(tx.version, next); err != nil {
+		return err
+	}
+	s.buckets = next
+	s.version = tx.version
+	return nil
+}
+
+func (s *store) persist(version int, buckets map[string]map[string]json.RawMessage) error {
+	if s.dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(storeFile{Version: version, Buckets: buckets})
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, storeFileName)
+	f, err := os.CreateTemp(s.dir, storeFileName+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (tx *storeTx) raw(bucket, key string) (json.RawMessage, bool) {
+	if w, ok := tx.writes[bucket]; ok {
+		if v, ok := w[key]; ok {
+			return v, v != nil
+		}
+	}
+	v, ok := tx.s.buckets[bucket][key]
+	return v, ok
+}
+
+// get decodes the value stored under bucket/key into v and reports whether it
+// was present.
+func (tx *storeTx) get(bucket, key string, v interface{}) (bool, error) {
+	data, ok := tx.raw(bucket, key)
+	if !ok {
+		return false, nil
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return true, fmt.Errorf("decode %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+func (tx *storeTx) put(bucket, key string, v interface{}) error {
+	if !tx.writable {
+		return errors.New("store: put in read-only transaction")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tx.stage(bucket, key, data)
+	return nil
+}
+
+func (tx *storeTx) delete(bucket, key string) error {
+	if !tx.writable {
+		return errors.New("store: delete in read-only transaction")
+	}
+	tx.stage(bucket, key, nil)
+	return nil
+}
+
+func (tx *storeTx) stage(bucket, key string, data json.RawMessage) {
+	w, ok := tx.writes[bucket]
+	if !ok {
+		w = map[string]json.RawMessage{}
+		tx.writes[bucket] = w
+	}
+	w[key] = data
+}
+
+// keys lists the keys present in bucket, sorted.
+func (tx *storeTx) keys(bucket string) []string {
+	seen := map[string]bool{}
+	for k := range tx.s.buckets[bucket] {
+		seen[k] = true
+	}
+	for k, v := range tx.writes[bucket] {
+		seen[k] = v != nil
+	}
+	var keys []string
+	for k, present := range seen {
+		if present {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// nextID hands out increasing integer IDs per bucket.
+func (tx *storeTx) nextID(bucket string) (int64, error) {
+	var id int64
+	if _, err := tx.get("_seq", bucket, &id); err != nil {
+		return 0, err
+	}
+	id++
+	return id, tx.put("_seq", bucket, id)
+}
+
+// repo is a typed view of one bucket.
+type repo[T any] struct {
+	st     *store
+	bucket string
+}
+
+func (r repo[T]) get(key string) (T, bool, error) {
+	var v T
+	var ok bool
+	err := r.st.view(func(tx *storeTx) error {
+		var err error
+		ok, err = tx.get(r.bucket, key, &v)
+		return err
+	})
+	return v, ok, err
+}
+
+func (r repo[T]) put(key string, v T) error {
+	return r.st.update(func(tx *storeTx) error {
+		return tx.put(r.bucket, key, v)
+	})
+}
+
+func (r repo[T]) delete(key string) error {
+	return r.st.update(func(tx *storeTx) error {
+		return tx.delete(r.bucket, key)
+	})
+}
+
+// all returns every value in the bucket keyed by its key.
+func (r repo[T]) all() (map[string]T, error) {
+	out := map[string]T{}
+	err := r.st.view(func(tx *storeTx) error {
+		for _, k := range tx.keys(r.bucket) {
+			var v T
+			if _, err := tx.get(r.bucket, k, &v); err != nil {
+				return err
+			}
+			out[k] = v
+		}
+		return nil
+	})
+	return out, err
+}
+
+// migrateURLNorm fills in urlRecord.Norm for history captured before reposts
+// were detected.
+func migrateURLNorm(tx *storeTx, dir string) error {
+	for _, k := range tx.keys(urlsBucket) {
+		var rec urlRecord
+		if _, err := tx.get(urlsBucket, k, &rec); err != nil {
+			return err
+		}
+		u, err := url.Parse(rec.Long)
+		if err != nil || rec.Norm != "" {
+			continue
+		}
+		rec.Norm = normalizeURL(u)
+		if err := tx.put(urlsBucket, k, rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStore_PutGetDelete(t *testing.T) {
+	st, _ := openStore("")
+	r := repo[string]{st: st, bucket: "things"}
+
+	if _, ok, _ := r.get("a"); ok {
+		t.Fatal("empty store reported a value")
+	}
+	if err := r.put("a", "apple"); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+	if v, ok, _ := r.get("a"); !ok || v != "apple" {
+		t.Errorf("get = %q, %v; want apple, true", v, ok)
+	}
+	if err := r.delete("a"); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if _, ok, _ := r.get("a"); ok {
+		t.Error("value still present after delete")
+	}
+}
+
+func TestStore_UpdateRollsBackOnError(t *testing.T) {
+	st, _ := openStore("")
+	boom := errors.New("boom")
+	err := st.update(func(tx *storeTx) error {
+		tx.put("things", "a", 1)
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("update error = %v, want boom", err)
+	}
+	st.view(func(tx *storeTx) error {
+		if len(tx.keys("things")) != 0 {
+			t.Error("failed update left data behind")
+		}
+		return nil
+	})
+}
+
+func TestStore_TxSeesOwnWrites(t *testing.T) {
+	st, _ := openStore("")
+	st.update(func(tx *storeTx) error {
+		tx.put("things", "a", 1)
+		tx.put("things", "b", 2)
+		tx.delete("things", "a")
+		if keys := tx.keys("things"); len(keys) != 1 || keys[0] != "b" {
+			t.Errorf("keys inside tx = %v, want [b]", keys)
+		}
+		return nil
+	})
+}
+
+func TestStore_ViewIsReadOnly(t *testing.T) {
+	st, _ := openStore("")
+	err := st.view(func(tx *storeTx) error {
+		return tx.put("things", "a", 1)
+	})
+	if err == nil {
+		t.Error("put inside view should fail")
+	}
+}
+
+func TestStore_NextID(t *testing.T) {
+	st, _ := openStore("")
+	var ids []int64
+	for i := 0; i < 3; i++ {
+		st.update(func(tx *storeTx) error {
+			id, err := tx.nextID("things")
+			ids = append(ids, id)
+			return err
+		})
+	}
+	if ids[0] != 1 || ids[1] != 2 || ids[2] != 3 {
+		t.Errorf("nextID sequence = %v, want [1 2 3]", ids)
+	}
+}
+
+func TestStore_PersistsAtomically(t *testing.T) {
+	dir := t.TempDir()
+	st, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := (repo[int]{st: st, bucket: "n"}).put("x", 7); err != nil {
+		t.Fatalf("put: %v", err)
+	}
+
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) == ".tmp" {
+			t.Errorf("temporary file left behind: %s", e.Name())
+		}
+	}
+
+	reopened, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	if v, ok, _ := (repo[int]{st: reopened, bucket: "n"}).get("x"); !ok || v != 7 {
+		t.Errorf("reopened value = %d, %v; want 7, true", v, ok)
+	}
+	if reopened.version != schemaVersion() {
+		t.Errorf("version = %d, want %d", reopened.version, schemaVersion())
+	}
+}
+
+func TestStore_RejectsNewerSchema(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, storeFileName), []byte(`{"version":9999,"buckets":{}}`), 0o644)
+	if _, err := openStore(dir); err == nil {
+		t.Error("opening a store from a newer build should fail")
+	}
+}
+
+func TestStore_RunsPendingMigrations(t *testing.T) {
+	saved := migrations
+	defer func() { migrations = saved }()
+	var ran []int
+	step := func(v int) func(tx *storeTx, dir string) error {
+		return func(tx *storeTx, dir string) error {
+			ran = append(ran, v)
+			return tx.put("steps", "last", v)
+		}
+	}
+	migrations = []migration{{1, "first", step(1)}, {2, "second", step(2)}}
+
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, storeFileName), []byte(`{"version":1,"buckets":{}}`), 0o644)
+	st, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if len(ran) != 1 || ran[0] != 2 || st.version != 2 {
+		t.Errorf("ran %v to version %d; want [2] to version 2", ran, st.version)
+	}
+	reopened, _ := openStore(dir)
+	if v, _, _ := (repo[int]{st: reopened, bucket: "steps"}).get("last"); v != 2 || len(ran) != 1 {
+		t.Errorf("migration result %d after %v; want 2 with no reruns", v, ran)
+	}
+}
+
+func TestStore_MigratesURLNorm(t *testing.T) {
+	dir := t.TempDir()
+	v0 := `{"version":0,"buckets":{"urls":{"000000000001":{"id":1,"long":"http://www.example.com/a/?utm_source=x"}}}}`
+	os.WriteFile(filepath.Join(dir, storeFileName), []byte(v0), 0o644)
+
+	st, err := openStore(dir)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	recs, _ := newURLStore(st).find(nil)
+	if len(recs) != 1 || recs[0].Norm != "https://example.com/a" {
+		t.Errorf("norm not backfilled: %+v", recs)
+	}
+}
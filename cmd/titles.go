@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// pageInfo is what we learned about a page by fetching it. Site-specific
+// previews fill in Summary, which replaces the title and description in the
+// announcement.
+type pageInfo struct {
+	Title       string
+	Description string
+	Summary     string
+}
+
+// titleFetcher fetches posted pages and pulls out their title and
+// description. Responses are capped in size and time, only HTML is read,
+// and results (including failures) are cached by normalized URL.
+type titleFetcher struct {
+	client    *http.Client
+	maxBytes  int64
+	deny      []string
+	ttl       time.Duration
+	providers []previewProvider
+
+	mu    sync.Mutex
+	cache map[string]titleCacheEntry
+}
+
+type titleCacheEntry struct {
+	info    pageInfo
+	expires time.Time
+}
+
+// titleCacheSize bounds the number of cached pages; the entry closest to
+// expiry is dropped to make room.
+const titleCacheSize = 500
+
+var errPrivateAddress = errors.New("refusing to fetch a private address")
+
+// newTitleFetcher builds a fetcher. Unless allowPrivate is set, connections to
+// loopback, private and link-local addresses are refused so players can't
+// use the bot to probe the network it runs on.
+func newTitleFetcher(timeout time.Duration, maxBytes int64, deny []string, allowPrivate bool) *titleFetcher {
+	return &titleFetcher{
+		client:    newPublicClient(timeout, allowPrivate),
+		maxBytes:  maxBytes,
+		deny:      deny,
+		ttl:       time.Hour,
+		cache:     map[string]titleCacheEntry{},
+		providers: defaultPreviewProviders(),
+	}
+}
+
+// newPublicClient returns a client for fetching player-supplied URLs. Unless
+// allowPrivate is set it refuses to connect to non-public addresses.
+func newPublicClient(timeout time.Duration, allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if !allowPrivate {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsUnspecified() {
+				return errPrivateAddress
+			}
+			return nil
+		}
+	}
+	transport := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		TLSHandshakeTimeout:   timeout,
+		ResponseHeaderTimeout: timeout,
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// denied reports whether host is on the denylist, either exactly or as a
+// subdomain of a listed domain.
+func (tf *titleFetcher) denied(host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range tf.deny {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if d != "" && (host == d || strings.HasSuffix(host, "."+d)) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetch returns the title and description of u, using the cache when it can.
+// A zero pageInfo means there was nothing worth announcing.
+func (tf *titleFetcher) fetch(u *url.URL, norm string) pageInfo {
+	if tf.denied(u.Hostname()) || (u.Scheme != "http" && u.Scheme != "https") {
+		return pageInfo{}
+	}
+
+	now := time.Now()
+	tf.mu.Lock()
+	if e, ok := tf.cache[norm]; ok && now.Before(e.expires) {
+		tf.mu.Unlock()
+		return e.info
+	}
+	tf.mu.Unlock()
+
+	info := tf.lookup(u)
+
+	tf.mu.Lock()
+	defer tf.mu.Unlock()
+	if len(tf.cache) >= titleCacheSize {
+		var oldest string
+		for k, e := range tf.cache {
+			if oldest == "" || e.expires.Before(tf.cache[oldest].expires) {
+				oldest = k
+			}
+		}
+		delete(tf.cache, oldest)
+	}
+	tf.cache[norm] = titleCacheEntry{info: info, expires: now.Add(tf.ttl)}
+	return info
+}
+
+// lookup asks the first preview provider that handles u, falling back to the
+// generic title extractor when there is none or it comes up empty.
+func (tf *titleFetcher) lookup(u *url.URL) pageInfo {
+	for _, p := range tf.providers {
+		if !p.matches(u) {
+			continue
+		}
+		info, err := p.preview(tf, u)
+		if err == nil && (info.Title != "" || info.Summary != "") {
+			return info
+		}
+		break
+	}
+	body, err := tf.getBody(u.String(), "text/html,application/xhtml+xml", "text/html", "application/xhtml+xml")
+	if err != nil {
+		return pageInfo{}
+	}
+	return extractPageInfo(string(body))
+}
+
+// getBody fetches rawURL and returns at most maxBytes of the body, provided
+// the response is a 200 with one of the given media types.
+func (tf *titleFetcher) getBody(rawURL, accept string, mediaTypes ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tf.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "Xephyr/"+version+" (MUSH link preview)")
+	req.Header.Set("Accept", accept)
+
+	res, err := tf.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", res.StatusCode)
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	ok := false
+	for _, mt := range mediaTypes {
+		ok = ok || mediaType == mt
+	}
+	if !ok {
+		return nil, fmt.Errorf("content type %q", mediaType)
+	}
+
+	return io.ReadAll(io.LimitReader(res.Body, tf.maxBytes))
+}
+
+var (
+	titleTagRe = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metaTagRe  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrRe     = regexp.MustCompile(`(?is)([a-z:-]+)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// extractPageInfo pulls the title and description out of an HTML document,
+// preferring OpenGraph tags to <title> and <meta name="description">.
+func extractPageInfo(doc string) pageInfo {
+	meta := map[string]string{}
+	for _, tag := range metaTagRe.FindAllString(doc, -1) {
+		attrs := map[string]string{}
+		for _, a := range attrRe.FindAllStringSubmatch(tag, -1) {
+			attrs[strings.ToLower(a[1])] = a[2] + a[3] + a[4]
+		}
+		key := strings.ToLower(attrs["property"])
+		if key == "" {
+			key = strings.ToLower(attrs["name"])
+		}
+		if key != "" && attrs["content"] != "" {
+			if _, seen := meta[key]; !seen {
+				meta[key] = attrs["content"]
+			}
+		}
+	}
+
+	var info pageInfo
+	info.Title = meta["og:title"]
+	if info.Title == "" {
+		if m := titleTagRe.FindStringSubmatch(doc); m != nil {
+			info.Title = m[1]
+		}
+	}
+	info.Description = meta["og:description"]
+	if info.Description == "" {
+		info.Description = meta["description"]
+	}
+	info.Title = cleanText(info.Title, 120)
+	info.Description = cleanText(info.Description, 160)
+	return info
+}
+
+// cleanText unescapes HTML entities, collapses whitespace and truncates to
+// max runes with an ellipsis.
+func cleanText(s string, max int) string {
+	s = strings.Join(strings.Fields(html.UnescapeString(s)), " ")
+	r := []rune(s)
+	if len(r) > max {
+		s = strings.TrimSpace(string(r[:max-3])) + "..."
+	}
+	return s
+}
+
+// announcement is the pose text for a captured URL.
+func (p pageInfo) announcement(short string) string {
+	out := "pose > " + mushEscape(short)
+	if p.Summary != "" {
+		return out + " " + mushEscape(p.Summary) + "\n"
+	}
+	if p.Title != "" {
+		out += " \"" + mushEscape(p.Title) + "\""
+	}
+	if p.Description != "" {
+		out += " - " + mushEscape(p.Description)
+	}
+	return out + "\n"
+}
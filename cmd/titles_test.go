@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExtractPageInfo(t *testing.T) {
+	cases := []struct {
+		name string
+		doc  string
+		want pageInfo
+	}{
+		{
+			"title only",
+			`<html><head><title>  Hello
+				World </title></head></html>`,
+			pageInfo{Title: "Hello World"},
+		},
+		{
+			"opengraph preferred",
+			`<head><title>Site | Page</title>
+			<meta property="og:title" content="Page">
+			<meta property="og:description" content="All about the page.">
+			<meta name="description" content="Fallback description"></head>`,
+			pageInfo{Title: "Page", Description: "All about the page."},
+		},
+		{
+			"meta description fallback, single quotes, attribute order",
+			`<title>T</title><meta content='Plain description' name='description'>`,
+			pageInfo{Title: "T", Description: "Plain description"},
+		},
+		{
+			"entities",
+			`<title>Fish &amp; Chips &#8211; &quot;Best&quot;</title>`,
+			pageInfo{Title: `Fish & Chips – "Best"`},
+		},
+		{
+			"no title",
+			`<html><body>nothing here</body></html>`,
+			pageInfo{},
+		},
+	}
+	for _, tc := range cases {
+		if got := extractPageInfo(tc.doc); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestCleanTextTruncates(t *testing.T) {
+	got := cleanText(strings.Repeat("a", 200), 20)
+	if len(got) != 20 || !strings.HasSuffix(got, "...") {
+		t.Errorf("cleanText truncation = %q", got)
+	}
+}
+
+func TestPageInfoAnnouncementEscapes(t *testing.T) {
+	got := pageInfo{Title: "[pemit(me,x)] 100%", Description: "d"}.announcement("https://y.rp/a")
+	want := "pose > https://y.rp/a \"\\[pemit(me,x)\\] 100\\%\" - d\n"
+	if got != want {
+		t.Errorf("announcement = %q, want %q", got, want)
+	}
+}
+
+func newTestTitleFetcher(deny ...string) *titleFetcher {
+	return newTitleFetcher(time.Second, 4096, deny, true)
+}
+
+func fetchURL(t *testing.T, tf *titleFetcher, raw string) pageInfo {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatalf("parse %q: %v", raw, err)
+	}
+	return tf.fetch(u, raw)
+}
+
+func TestTitleFetcher_FetchesHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, `<title>Served Page</title>`)
+	}))
+	defer srv.Close()
+
+	if got := fetchURL(t, newTestTitleFetcher(), srv.URL); got.Title != "Served Page" {
+		t.Errorf("title = %q", got.Title)
+	}
+}
+
+func TestTitleFetcher_SkipsNonHTML(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		fmt.Fprint(w, `<title>not really</title>`)
+	}))
+	defer srv.Close()
+
+	if got := fetchURL(t, newTestTitleFetcher(), srv.URL); got != (pageInfo{}) {
+		t.Errorf("non-HTML response produced %+v", got)
+	}
+}
+
+func TestTitleFetcher_SizeLimit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, strings.Repeat(" ", 8192)+`<title>Too Far Down</title>`)
+	}))
+	defer srv.Close()
+
+	if got := fetchURL(t, newTestTitleFetcher(), srv.URL); got.Title != "" {
+		t.Errorf("title beyond the size limit was read: %q", got.Title)
+	}
+}
+
+func TestTitleFetcher_Timeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Slow</title>`)
+	}))
+	defer srv.Close()
+
+	tf := newTitleFetcher(50*time.Millisecond, 4096, nil, true)
+	if got := fetchURL(t, tf, srv.URL); got.Title != "" {
+		t.Errorf("slow page should time out, got %q", got.Title)
+	}
+}
+
+func TestTitleFetcher_Denylist(t *testing.T) {
+	tf := newTestTitleFetcher("example.com")
+	for _, host := range []string{"example.com", "www.example.com", "EXAMPLE.com"} {
+		if !tf.denied(host) {
+			t.Errorf("%s should be denied", host)
+		}
+	}
+	for _, host := range []string{"notexample.com", "example.org"} {
+		if tf.denied(host) {
+			t.Errorf("%s should not be denied", host)
+		}
+	}
+}
+
+func TestTitleFetcher_Caches(t *testing.T) {
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Cached</title>`)
+	}))
+	defer srv.Close()
+
+	tf := newTestTitleFetcher()
+	fetchURL(t, tf, srv.URL)
+	if got := fetchURL(t, tf, srv.URL); got.Title != "Cached" {
+		t.Errorf("cached title = %q", got.Title)
+	}
+	if hits != 1 {
+		t.Errorf("server hit %d times, want 1", hits)
+	}
+}
+
+func TestTitleFetcher_RefusesPrivateAddresses(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>Internal</title>`)
+	}))
+	defer srv.Close()
+
+	tf := newTitleFetcher(time.Second, 4096, nil, false)
+	if got := fetchURL(t, tf, srv.URL); got.Title != "" {
+		t.Errorf("loopback page was fetched: %q", got.Title)
+	}
+}
+
+func TestProcessUrls_AnnouncesTitle(t *testing.T) {
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<title>A Fine Page</title>`)
+	}))
+	defer page.Close()
+	yirp := newYirpServer(t)
+	defer yirp.Close()
+
+	app := newTestApp()
+	app.config.yirpAPIAddr = yirp.URL
+	app.titles = newTestTitleFetcher()
+
+	got, _ := app.processUrls("#1234", "Dino", "#20", []string{page.URL + "/a"})
+	if !strings.HasSuffix(got, "pose > https://y.rp/s1 \"A Fine Page\"\n") {
+		t.Errorf("announcement missing title: %q", got)
+	}
+	if strings.Contains(got, "TRIGGER_LAST_URL") {
+		t.Errorf("titled URL should not use the softcode trigger: %q", got)
+	}
+	if recs, _ := app.urls.find(nil); recs[0].Title != "A Fine Page" {
+		t.Errorf("title not stored: %+v", recs[0])
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Unit systems for weather output. An empty system means "whatever is usual
+// where the location is", which is imperial for the US and metric elsewhere.
+const (
+	unitsMetric   = "metric"
+	unitsImperial = "imperial"
+	unitsBoth     = "both"
+)
+
+// unitFlags are the per-request unit switches, e.g. "gbw -f London".
+var unitFlags = map[string]string{
+	"-f": unitsImperial, "--f": unitsImperial, "--imperial": unitsImperial, "-i": unitsImperial,
+	"-c": unitsMetric, "--c": unitsMetric, "--metric": unitsMetric, "-m": unitsMetric,
+	"-b": unitsBoth, "--both": unitsBoth,
+}
+
+// parseUnitFlags removes unit switches from args, returning what is left and
+// the last switch given ("" if none).
+func parseUnitFlags(args string) (string, string) {
+	var rest []string
+	units := ""
+	for _, f := range strings.Fields(args) {
+		if u, ok := unitFlags[strings.ToLower(f)]; ok {
+			units = u
+			continue
+		}
+		rest = append(rest, f)
+	}
+	return strings.Join(rest, " "), units
+}
+
+// chooseUnits picks the unit system for a request: the request's own switch,
+// then the player's preference, then the world default set with -units.
+func (app *application) chooseUnits(requested, userID string) string {
+	if requested != "" {
+		return requested
+	}
+	if pref := app.prefs.get(userID).Units; pref != "" {
+		return pref
+	}
+	return app.config.units
+}
+
+// resolveUnits settles an automatic choice using the location's country.
+func resolveUnits(units, country string) string {
+	if units != "" {
+		return units
+	}
+	if isUSA(country) {
+		return unitsImperial
+	}
+	return unitsMetric
+}
+
+func isUSA(country string) bool {
+	return strings.HasPrefix(country, "United States") || strings.HasPrefix(country, "USA")
+}
+
+// formatTemp renders a temperature, e.g. "75.4F", "24.1C" or "75.4F/24.1C".
+func formatTemp(c, f float64, units string) string {
+	return pickUnits(fmt.Sprintf("%.1fC", c), fmt.Sprintf("%.1fF", f), units)
+}
+
+// formatTempRange renders a high/low pair, e.g. "75/58F" or "75/58F (24/15C)".
+func formatTempRange(hiC, loC, hiF, loF float64, units string) string {
+	metric := fmt.Sprintf("%.0f/%.0fC", hiC, loC)
+	imperial := fmt.Sprintf("%.0f/%.0fF", hiF, loF)
+	if units == unitsBoth {
+		return imperial + " (" + metric + ")"
+	}
+	return pickUnits(metric, imperial, units)
+}
+
+// formatSpeed renders a wind speed, e.g. "8.1mph" or "8.1mph/13.0kph".
+func formatSpeed(kph, mph float64, units string) string {
+	return pickUnits(fmt.Sprintf("%.1fkph", kph), fmt.Sprintf("%.1fmph", mph), units)
+}
+
+// formatPrecip renders a precipitation amount, e.g. "0.12in" or "3.0mm".
+func formatPrecip(mm, in float64, units string) string {
+	return pickUnits(fmt.Sprintf("%.1fmm", mm), fmt.Sprintf("%.2fin", in), units)
+}
+
+func pickUnits(metric, imperial, units string) string {
+	switch units {
+	case unitsImperial:
+		return imperial
+	case unitsBoth:
+		return imperial + "/" + metric
+	}
+	return metric
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const currentFixture = `{
+  "location": {"name": "%s", "region": "Massachusetts", "country": "%s"},
+  "current": {"temp_c": 24.1, "temp_f": 75.4, "humidity": 61, "wind_kph": 13, "wind_mph": 8.1,
+    "wind_dir": "SW", "condition": {"text": "Sunny"}}
+}`
+
+func newCurrentServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "London" {
+			fmt.Fprintf(w, currentFixture, "London", "United Kingdom")
+			return
+		}
+		fmt.Fprintf(w, currentFixture, "Boston", "United States of America")
+	}))
+}
+
+func TestParseUnitFlags(t *testing.T) {
+	tests := []struct {
+		args, rest, units string
+	}{
+		{"Boston", "Boston", ""},
+		{"-f London", "London", unitsImperial},
+		{"London -C", "London", unitsMetric},
+		{"--both Boston, London", "Boston, London", unitsBoth},
+		{"-c -f Paris", "Paris", unitsImperial},
+		{"40.7 -74.0", "40.7 -74.0", ""},
+		{"-b", "", unitsBoth},
+	}
+	for _, tt := range tests {
+		rest, units := parseUnitFlags(tt.args)
+		if rest != tt.rest || units != tt.units {
+			t.Errorf("parseUnitFlags(%q) = %q, %q; want %q, %q", tt.args, rest, units, tt.rest, tt.units)
+		}
+	}
+}
+
+func TestChooseUnits(t *testing.T) {
+	app := newTestApp()
+	if got := app.chooseUnits("", "#42"); got != "" {
+		t.Errorf("no preference anywhere: %q", got)
+	}
+	app.config.units = unitsBoth
+	if got := app.chooseUnits("", "#42"); got != unitsBoth {
+		t.Errorf("world default ignored: %q", got)
+	}
+	app.prefs.set("#42", "units", "c")
+	if got := app.chooseUnits("", "#42"); got != unitsMetric {
+		t.Errorf("player preference ignored: %q", got)
+	}
+	if got := app.chooseUnits(unitsImperial, "#42"); got != unitsImperial {
+		t.Errorf("request switch ignored: %q", got)
+	}
+}
+
+func TestUnitFormatting(t *testing.T) {
+	tests := []struct {
+		got, want string
+	}{
+		{formatTemp(24.1, 75.4, unitsMetric), "24.1C"},
+		{formatTemp(24.1, 75.4, unitsImperial), "75.4F"},
+		{formatTemp(24.1, 75.4, unitsBoth), "75.4F/24.1C"},
+		{formatSpeed(13, 8.1, unitsBoth), "8.1mph/13.0kph"},
+		{formatPrecip(3, 0.12, unitsImperial), "0.12in"},
+		{formatPrecip(3, 0.12, unitsMetric), "3.0mm"},
+		{formatTempRange(24.1, 14.6, 75.4, 58.3, unitsBoth), "75/58F (24/15C)"},
+		{resolveUnits("", "USA United States of America"), unitsImperial},
+		{resolveUnits("", "France"), unitsMetric},
+	}
+	for i, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("case %d: got %q, want %q", i, tt.got, tt.want)
+		}
+	}
+}
+
+func TestCheckLine_WeatherUnits(t *testing.T) {
+	srv := newCurrentServer(t)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	tests := []struct {
+		line, want string
+	}{
+		{`[Rex(#99)] Rex says "gravybot weather Boston"`, "Boston, Massachusetts: Sunny 75.4F 61.0%% 8.1mph SW"},
+		{`[Rex(#99)] Rex says "gravybot weather London"`, "London, United Kingdom: Sunny 24.1C 61.0%% 13.0kph SW"},
+		{`[Rex(#99)] Rex says "gravybot weather -c Boston"`, "Boston, Massachusetts: Sunny 24.1C 61.0%% 13.0kph SW"},
+		{`[Rex(#99)] Rex says "gravybot weather London -f"`, "London, United Kingdom: Sunny 75.4F 61.0%% 8.1mph SW"},
+		{`[Rex(#99)] Rex says "gravybot weather --both London"`, "London, United Kingdom: Sunny 75.4F/24.1C 61.0%% 8.1mph/13.0kph SW"},
+	}
+	for _, tt := range tests {
+		got, _ := app.checkLineForRegexps(tt.line)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("%s\ngot  %q\nwant %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestHandleForecast_UnitSwitchAndPrecip(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"location": {"name": "Paris", "region": "", "country": "France"},
+  "forecast": {"forecastday": [{"date": "2026-06-03", "day": {"maxtemp_c": 20, "maxtemp_f": 68, "mintemp_c": 10,
+    "mintemp_f": 50, "totalprecip_mm": 4.2, "totalprecip_in": 0.17, "daily_chance_of_rain": 80,
+    "condition": {"text": "Rain"}}}]}}`)
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+	app.prefs.set("#42", "units", "metric")
+
+	if got := app.handleForecast("#42", "Paris 1"); !strings.Contains(got, "Rain 20/10C 80%% rain 4.2mm") {
+		t.Errorf("metric: %q", got)
+	}
+	if got := app.handleForecast("#42", "--both Paris 1"); !strings.Contains(got, "Rain 68/50F (20/10C) 80%% rain 0.17in/4.2mm") {
+		t.Errorf("both: %q", got)
+	}
+}
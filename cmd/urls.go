@@ -0,0 +1,486 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const urlsBucket = "urls"
+
+// urlsPerPage is how many history entries a single urls reply shows.
+const urlsPerPage = 5
+
+// urlRecord is one URL captured from the game.
+type urlRecord struct {
+	ID     int64     `json:"id"`
+	Poster string    `json:"poster"` // dbref of the player who posted it
+	Name   string    `json:"name"`
+	Where  string    `json:"where"` // room dbref, or "channel:<name>"
+	Posted time.Time `json:"posted"`
+	Short  string    `json:"short"`
+	Long   string    `json:"long"`
+	Norm   string    `json:"norm"` // normalizeURL(Long), used to spot reposts
+	Title  string    `json:"title,omitempty"`
+
+	// Filled in by the link checker.
+	Status   int       `json:"status,omitempty"` // last HTTP status, 0 if unreachable
+	Checked  time.Time `json:"checked"`
+	Failures int       `json:"failures,omitempty"` // consecutive failed checks
+}
+
+// deadAfter is how many checks in a row must fail before a link is dead.
+const deadAfter = 2
+
+// dead reports whether the link checker has given up on the URL.
+func (r urlRecord) dead() bool {
+	return r.Failures >= deadAfter
+}
+
+// urlStore is the URL history kept in the urls bucket, keyed by zero-padded
+// ID so that keys sort oldest first.
+type urlStore struct {
+	st *store
+}
+
+func newURLStore(st *store) *urlStore {
+	return &urlStore{st: st}
+}
+
+func idKey(id int64) string {
+	return fmt.Sprintf("%012d", id)
+}
+
+// add assigns rec the next ID and stores it.
+func (us *urlStore) add(rec urlRecord) (urlRecord, error) {
+	err := us.st.update(func(tx *storeTx) error {
+		id, err := tx.nextID(urlsBucket)
+		if err != nil {
+			return err
+		}
+		rec.ID = id
+		return tx.put(urlsBucket, idKey(id), rec)
+	})
+	return rec, err
+}
+
+// addOlder stores recs, oldest first, ahead of the records already there,
+// which are renumbered after them starting from the lowest of their IDs, so
+// record IDs stay sequential for the ring mirror. The imported records are dated a second
+// apart up to before, or up to the oldest stored record if that is earlier.
+func (us *urlStore) addOlder(recs []urlRecord, before time.Time) error {
+	if len(recs) == 0 {
+		return nil
+	}
+	return us.st.update(func(tx *storeTx) error {
+		var later []urlRecord
+		for _, k := range tx.keys(urlsBucket) {
+			var rec urlRecord
+			if _, err := tx.get(urlsBucket, k, &rec); err != nil {
+				return err
+			}
+			if rec.Posted.Before(before) {
+				before = rec.Posted
+			}
+			later = append(later, rec)
+			if err := tx.delete(urlsBucket, k); err != nil {
+				return err
+			}
+		}
+		if len(later) > 0 {
+			if err := tx.put("_seq", urlsBucket, later[0].ID-1); err != nil {
+				return err
+			}
+		}
+		for i := range recs {
+			recs[i].Posted = before.Add(time.Duration(i-len(recs)) * time.Second)
+		}
+		for _, rec := range append(recs, later...) {
+			id, err := tx.nextID(urlsBucket)
+			if err != nil {
+				return err
+			}
+			rec.ID = id
+			if err := tx.put(urlsBucket, idKey(id), rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// find returns the records accepted by match, newest first.
+func (us *urlStore) find(match func(urlRecord) bool) ([]urlRecord, error) {
+	var out []urlRecord
+	err := us.st.view(func(tx *storeTx) error {
+		keys := tx.keys(urlsBucket)
+		for i := len(keys) - 1; i >= 0; i-- {
+			var rec urlRecord
+			if _, err := tx.get(urlsBucket, keys[i], &rec); err != nil {
+				return err
+			}
+			if match == nil || match(rec) {
+				out = append(out, rec)
+			}
+		}
+		return nil
+	})
+	return out, err
+}
+
+// linkCheck is the outcome of checking one URL.
+type linkCheck struct {
+	status int
+	failed bool
+}
+
+// recordChecks stores a pass of link checks, keyed by normalized URL, on
+// every record of each URL in a single update. A failed check counts once
+// per URL however often it was posted. It returns how many of the checked
+// URLs are now dead.
+func (us *urlStore) recordChecks(checks map[string]linkCheck, at time.Time) (int, error) {
+	if len(checks) == 0 {
+		return 0, nil
+	}
+	dead := 0
+	err := us.st.update(func(tx *storeTx) error {
+		keys := tx.keys(urlsBucket)
+		recs := make([]urlRecord, len(keys))
+		failures := map[string]int{}
+		for i, k := range keys {
+			if _, err := tx.get(urlsBucket, k, &recs[i]); err != nil {
+				return err
+			}
+			if norm := recs[i].Norm; recs[i].Failures > failures[norm] {
+				failures[norm] = recs[i].Failures
+			}
+		}
+		for norm, c := range checks {
+			failures[norm]++
+			if !c.failed {
+				failures[norm] = 0
+			}
+			if failures[norm] >= deadAfter {
+				dead++
+			}
+		}
+		for i, rec := range recs {
+			c, ok := checks[rec.Norm]
+			if !ok {
+				continue
+			}
+			rec.Status, rec.Checked, rec.Failures = c.status, at, failures[rec.Norm]
+			if err := tx.put(urlsBucket, keys[i], rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return dead, err
+}
+
+// firstPosted returns the earliest record of the normalized URL posted at or
+// after since.
+func (us *urlStore) firstPosted(norm string, since time.Time) (urlRecord, bool, error) {
+	recs, err := us.find(func(r urlRecord) bool {
+		return r.Norm == norm && !r.Posted.Before(since)
+	})
+	if err != nil || len(recs) == 0 {
+		return urlRecord{}, false, err
+	}
+	return recs[len(recs)-1], true, nil
+}
+
+// trackingParams are query parameters that only identify where a link was
+// shared from and so are dropped when comparing URLs.
+var trackingParams = map[string]bool{
+	"fbclid": true, "gclid": true, "dclid": true, "msclkid": true, "igshid": true,
+	"mc_cid": true, "mc_eid": true, "ref_src": true, "ref_url": true, "si": true,
+	"_hsenc": true, "_hsmi": true, "yclid": true, "twclid": true,
+}
+
+// normalizeURL reduces a URL to the form used to detect reposts: http and
+// https are treated alike, the host is lowercased without a leading "www." or
+// default port, trailing slashes and the fragment are dropped, and tracking
+// parameters are removed with the rest sorted.
+func normalizeURL(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	host = strings.TrimPrefix(host, "www.")
+	scheme := strings.ToLower(u.Scheme)
+	if port := u.Port(); port != "" && !(port == "80" && scheme == "http") && !(port == "443" && scheme == "https") {
+		host += ":" + port
+	}
+	if scheme == "http" {
+		scheme = "https"
+	}
+
+	q := u.Query()
+	for k := range q {
+		if trackingParams[strings.ToLower(k)] || strings.HasPrefix(strings.ToLower(k), "utm_") {
+			q.Del(k)
+		}
+	}
+
+	norm := scheme + "://" + host + strings.TrimRight(u.EscapedPath(), "/")
+	if enc := q.Encode(); enc != "" { // Encode sorts by key
+		norm += "?" + enc
+	}
+	return norm
+}
+
+// ago describes a duration in the past the way a person would.
+func ago(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return "1 " + unit + " ago"
+		}
+		return strconv.Itoa(n) + " " + unit + "s ago"
+	}
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	default:
+		return plural(int(d/(24*time.Hour)), "day")
+	}
+}
+
+var urlsCmdRe = regexp.MustCompile(`(?i)^(search|by|today|dead)\s*(.*?)(?:\s+page\s+(\d+))?$`)
+
+// handleURLs implements "gravybot urls search|by|today ...", replying to the
+// requester with one page of matching history.
+func (app *application) handleURLs(userID, args string, now time.Time) string {
+	if fields := strings.Fields(args); len(fields) > 0 {
+		switch strings.ToLower(fields[0]) {
+		case "optout":
+			return app.handleURLOptOut(userID, true)
+		case "optin":
+			return app.handleURLOptOut(userID, false)
+		case "room":
+			return app.handleURLRoom(userID, fields[1:])
+		case "reload":
+			return app.handleBlocklistReload(userID)
+		}
+	}
+
+	m := urlsCmdRe.FindStringSubmatch(strings.TrimSpace(args))
+	if m == nil {
+		return "@pemit " + userID + "=Gravybot: try gravybot urls search <term>, urls by <player>, urls today, urls dead \\[page <N>\\], or urls optout\n"
+	}
+	sub, arg := strings.ToLower(m[1]), strings.TrimSpace(m[2])
+	page := 1
+	if m[3] != "" {
+		page, _ = strconv.Atoi(m[3])
+	}
+
+	var title string
+	var match func(urlRecord) bool
+	switch sub {
+	case "search":
+		if arg == "" {
+			return "@pemit " + userID + "=Gravybot: search for what?\n"
+		}
+		term := strings.ToLower(arg)
+		title = "URLs matching '" + arg + "'"
+		match = func(r urlRecord) bool {
+			return strings.Contains(strings.ToLower(r.Long), term) ||
+				strings.Contains(strings.ToLower(r.Short), term) ||
+				strings.Contains(strings.ToLower(r.Title), term) ||
+				strings.Contains(strings.ToLower(r.Name), term)
+		}
+	case "by":
+		if arg == "" {
+			return "@pemit " + userID + "=Gravybot: by whom?\n"
+		}
+		title = "URLs posted by " + arg
+		match = func(r urlRecord) bool {
+			return strings.EqualFold(r.Name, arg) || r.Poster == arg
+		}
+	case "today":
+		loc := time.Local
+		if tz := app.prefs.get(userID).Timezone; tz != "" {
+			if l, err := time.LoadLocation(tz); err == nil {
+				loc = l
+			}
+		}
+		local := now.In(loc)
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		title = "URLs posted today"
+		match = func(r urlRecord) bool {
+			return !r.Posted.Before(midnight)
+		}
+	case "dead":
+		title = "Dead URLs"
+		match = urlRecord.dead
+	}
+
+	recs, err := app.urls.find(match)
+	if err != nil {
+		app.errorLog.Printf("url history %s: %s", sub, err)
+		return "@pemit " + userID + "=Gravybot: URL history is unavailable right now.\n"
+	}
+	return "@pemit " + userID + "=" + formatURLPage(title, recs, page) + "\n"
+}
+
+// formatURLPage renders one page of records as a single MUSH message, using
+// %r between lines.
+func formatURLPage(title string, recs []urlRecord, page int) string {
+	if len(recs) == 0 {
+		return mushEscape(title) + ": none."
+	}
+	pages := (len(recs) + urlsPerPage - 1) / urlsPerPage
+	if page < 1 {
+		page = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	start := (page - 1) * urlsPerPage
+	end := start + urlsPerPage
+	if end > len(recs) {
+		end = len(recs)
+	}
+
+	lines := []string{fmt.Sprintf("%s (page %d/%d):", mushEscape(title), page, pages)}
+	for _, r := range recs[start:end] {
+		lines = append(lines, formatURLRecord(r))
+	}
+	return strings.Join(lines, "%r")
+}
+
+func formatURLRecord(r urlRecord) string {
+	line := fmt.Sprintf("%d) %s -> %s %s(%s) %s", r.ID, mushEscape(r.Short), mushEscape(r.Long),
+		mushEscape(r.Name), r.Poster, r.Posted.Format("Jan 2"))
+	if r.dead() {
+		line += " \\[dead\\]"
+	}
+	return line
+}
+
+// urlPostsSize is how many lines with URLs wait for the capture worker.
+const urlPostsSize = 50
+
+// urlPost is the URLs from one line, waiting to be captured.
+type urlPost struct {
+	authorID, authorName, where string
+	urls                        []string
+}
+
+// postURLs hands a line's URLs to captureURLs so that shortening and title
+// fetches don't hold up reading from the game. It never blocks; when the
+// worker has fallen that far behind, the post is dropped.
+func (app *application) postURLs(p urlPost) {
+	select {
+	case app.urlPosts <- p:
+	default:
+		app.errorLog.Printf("url queue full, dropped %d URLs from %s", len(p.urls), p.authorID)
+	}
+}
+
+// captureURLs processes posted URLs one line at a time, in the order they
+// were said, and queues the resulting commands on the outbox.
+func (app *application) captureURLs() {
+	for p := range app.urlPosts {
+		out, err := app.processUrls(p.authorID, p.authorName, p.where, p.urls)
+		if err != nil {
+			app.errorLog.Printf("urls from %s: %s", p.authorID, err)
+		}
+		if out != "" {
+			app.queue(out)
+		}
+	}
+}
+
+func (app *application) processUrls(authorID, authorName, where string, urls []string) (string, error) {
+	var botData string = ""
+	now := time.Now().UTC()
+	if app.prefs.get(authorID).URLs == "off" || app.urlCaptureOff(where) {
+		return "", nil
+	}
+	for _, longUrl := range urls {
+		if strings.HasPrefix(strings.ToLower(longUrl), "www") {
+			longUrl = "http://" + longUrl
+		}
+		u, err := url.Parse(longUrl)
+		if err != nil {
+			return "", err
+		}
+		action := app.policy.decide(u)
+		if action == urlIgnore {
+			continue
+		}
+		if warning, blocked := app.screenURL(authorID, authorName, u.Hostname()); blocked {
+			botData = botData + warning
+			continue
+		}
+		rec := urlRecord{
+			Poster: authorID,
+			Name:   authorName,
+			Where:  where,
+			Posted: now,
+			Long:   u.String(),
+			Norm:   normalizeURL(u),
+		}
+
+		if app.config.urlDupWindow > 0 {
+			first, ok, err := app.urls.firstPosted(rec.Norm, now.Add(-app.config.urlDupWindow))
+			if err != nil {
+				app.errorLog.Printf("url history lookup: %s", err)
+			}
+			if ok {
+				rec.Short, rec.Title = first.Short, first.Title
+				if saved, err := app.urls.add(rec); err != nil {
+					app.errorLog.Printf("url history add: %s", err)
+				} else if app.mirrorEnabled() {
+					botData = botData + app.mirrorURLs([]urlRecord{saved}, saved.ID)
+				}
+				announce := strings.TrimSuffix(pageInfo{Title: first.Title}.announcement(first.Short), "\n")
+				botData = botData + announce + " (first posted by " + mushEscape(first.Name) + " " + ago(now.Sub(first.Posted)) + ")\n"
+				continue
+			}
+		}
+
+		shortUrl, by, err := u.String(), "policy", error(nil)
+		if action == urlShorten {
+			shortUrl, by, err = app.shorteners.shorten(u.String())
+		}
+		if err != nil {
+			app.errorLog.Printf("shorten %s: %s", u.String(), err)
+			if !app.config.recordUnshortened {
+				continue
+			}
+			shortUrl = u.String()
+		} else {
+			app.infoLog.Printf("shortened %s with %s", u.String(), by)
+		}
+
+		var info pageInfo
+		if app.titles != nil {
+			info = app.titles.fetch(u, rec.Norm)
+		}
+		rec.Short, rec.Title = shortUrl, info.Title
+		saved, err := app.urls.add(rec)
+		if err != nil {
+			app.errorLog.Printf("url history add: %s", err)
+		}
+		if err == nil && app.mirrorEnabled() {
+			botData = botData + app.mirrorURLs([]urlRecord{saved}, saved.ID)
+		} else {
+			botData = botData + "add_url " + authorID + " " + shortUrl + " " + u.String() + "\n"
+		}
+		if info.Title != "" || info.Summary != "" {
+			botData = botData + info.announcement(shortUrl)
+		} else {
+			botData = botData + "@trigger me/TRIGGER_LAST_URL\n"
+		}
+	}
+	app.infoLog.Printf("botData: %s\n", botData)
+
+	return botData, nil
+}
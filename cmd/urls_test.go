@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// seedURLs stores n records posted an hour apart, oldest first, ending at end.
+func seedURLs(t *testing.T, app *application, n int, end time.Time) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		name, poster := "Dino", "#1234"
+		if i%2 == 1 {
+			name, poster = "Rex", "#99"
+		}
+		_, err := app.urls.add(urlRecord{
+			Poster: poster,
+			Name:   name,
+			Where:  "#20",
+			Posted: end.Add(time.Duration(i-n+1) * time.Hour),
+			Short:  fmt.Sprintf("https://y.rp/%d", i),
+			Long:   fmt.Sprintf("https://example.com/page/%d", i),
+		})
+		if err != nil {
+			t.Fatalf("add: %v", err)
+		}
+	}
+}
+
+// newYirpServer answers every shorten request with a short URL derived from a
+// running counter.
+func newYirpServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	n := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req YirpRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		n++
+		json.NewEncoder(w).Encode(YirpResponse{ShortUrl: fmt.Sprintf("https://y.rp/s%d", n), LongUrl: req.LongUrl})
+	}))
+}
+
+func TestURLStore_FindNewestFirst(t *testing.T) {
+	app := newTestApp()
+	seedURLs(t, app, 3, time.Now())
+	recs, err := app.urls.find(nil)
+	if err != nil {
+		t.Fatalf("find: %v", err)
+	}
+	if len(recs) != 3 || recs[0].ID != 3 || recs[2].ID != 1 {
+		t.Errorf("unexpected order: %+v", recs)
+	}
+}
+
+func TestHandleURLs_Search(t *testing.T) {
+	app := newTestApp()
+	seedURLs(t, app, 12, time.Now())
+	got := app.handleURLs("#1", "search page/1", time.Now())
+	// page/1, page/10, page/11
+	if !strings.HasPrefix(got, "@pemit #1=URLs matching 'page/1' (page 1/1):") {
+		t.Errorf("unexpected header: %q", got)
+	}
+	if strings.Count(got, "%r") != 3 {
+		t.Errorf("expected 3 results, got: %q", got)
+	}
+}
+
+func TestHandleURLs_ByPlayerPaged(t *testing.T) {
+	app := newTestApp()
+	seedURLs(t, app, 14, time.Now())
+
+	first := app.handleURLs("#1", "by dino", time.Now())
+	if !strings.Contains(first, "(page 1/2)") || strings.Count(first, "%r") != urlsPerPage {
+		t.Errorf("unexpected first page: %q", first)
+	}
+	if strings.Contains(first, "Rex") {
+		t.Errorf("by dino returned Rex's URLs: %q", first)
+	}
+	second := app.handleURLs("#1", "by #1234 page 2", time.Now())
+	if !strings.Contains(second, "(page 2/2)") || strings.Count(second, "%r") != 2 {
+		t.Errorf("unexpected second page: %q", second)
+	}
+}
+
+func TestHandleURLs_Today(t *testing.T) {
+	app := newTestApp()
+	app.prefs.set("#1", "tz", "UTC")
+	now := time.Date(2026, 6, 3, 3, 30, 0, 0, time.UTC)
+	seedURLs(t, app, 6, now) // 22:30, 23:30 yesterday; 00:30 .. 03:30 today
+	got := app.handleURLs("#1", "today", now)
+	if strings.Count(got, "%r") != 4 {
+		t.Errorf("expected 4 URLs today, got: %q", got)
+	}
+}
+
+func TestHandleURLs_NoneAndUsage(t *testing.T) {
+	app := newTestApp()
+	if got := app.handleURLs("#1", "search nothing", time.Now()); got != "@pemit #1=URLs matching 'nothing': none.\n" {
+		t.Errorf("unexpected empty reply: %q", got)
+	}
+	if got := app.handleURLs("#1", "frobnicate", time.Now()); !strings.Contains(got, "try gravybot urls") {
+		t.Errorf("expected usage, got: %q", got)
+	}
+}
+
+// startURLCapture runs app's URL capture worker and returns a function that
+// waits for the next command it queues.
+func startURLCapture(t *testing.T, app *application) func() string {
+	t.Helper()
+	app.outbox = make(chan string, outboxSize)
+	app.urlPosts = make(chan urlPost, urlPostsSize)
+	go app.captureURLs()
+	t.Cleanup(func() { close(app.urlPosts) })
+	return func() string {
+		t.Helper()
+		select {
+		case out := <-app.outbox:
+			return out
+		case <-time.After(5 * time.Second):
+			t.Fatal("nothing queued by the URL capture")
+			return ""
+		}
+	}
+}
+
+func TestCheckLine_URLRecordedWithPosterAndRoom(t *testing.T) {
+	srv := newYirpServer(t)
+	defer srv.Close()
+
+	app := newTestApp()
+	app.config.yirpAPIAddr = srv.URL
+	next := startURLCapture(t, app)
+	app.checkLineForRegexps("XEPHYR-ROOM: #20 The Hangout")
+	if got, err := app.checkLineForRegexps(`[Dino(#1234)] Dino says "look https://example.com/a"`); err != nil || got != "" {
+		t.Fatalf("capture should happen off the read loop, got %q, %v", got, err)
+	}
+	app.checkLineForRegexps(`[Rex(#99)] <Public> Rex says "and https://example.com/b"`)
+	next()
+	next()
+
+	recs, _ := app.urls.find(nil)
+	if len(recs) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(recs))
+	}
+	if r := recs[1]; r.Poster != "#1234" || r.Name != "Dino" || r.Where != "#20" || r.Short != "https://y.rp/s1" {
+		t.Errorf("unexpected first record: %+v", r)
+	}
+	if r := recs[0]; r.Where != "channel:Public" {
+		t.Errorf("channel not recorded: %+v", r)
+	}
+}
+
+func TestCheckLine_URLSearchNotCaptured(t *testing.T) {
+	app := newTestApp()
+	cmd, _ := app.checkLineForRegexps(`[Dino(#1234)] Dino says "gravybot urls search https://example.com"`)
+	if !strings.HasPrefix(cmd, "@pemit #1234=") {
+		t.Errorf("expected history reply, got: %q", cmd)
+	}
+	if recs, _ := app.urls.find(nil); len(recs) != 0 {
+		t.Errorf("search term was captured as a URL: %+v", recs)
+	}
+}
+
+func TestNormalizeURL(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"https://example.com/a", "https://example.com/a"},
+		{"http://example.com/a", "https://example.com/a"},
+		{"HTTPS://Example.COM/a/", "https://example.com/a"},
+		{"https://www.example.com/a", "https://example.com/a"},
+		{"https://example.com:443/a", "https://example.com/a"},
+		{"http://example.com:80/a", "https://example.com/a"},
+		{"https://example.com:8443/a", "https://example.com:8443/a"},
+		{"https://example.com/", "https://example.com"},
+		{"https://example.com", "https://example.com"},
+		{"https://example.com/a#section", "https://example.com/a"},
+		{"https://example.com/a?utm_source=x&utm_medium=y", "https://example.com/a"},
+		{"https://example.com/a?b=2&a=1", "https://example.com/a?a=1&b=2"},
+		{"https://example.com/a?id=5&fbclid=abc", "https://example.com/a?id=5"},
+		{"https://youtu.be/xyz?si=tracking", "https://youtu.be/xyz"},
+		// path case is significant
+		{"https://example.com/A", "https://example.com/A"},
+	}
+	for _, tc := range cases {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatalf("parse %q: %v", tc.in, err)
+		}
+		if got := normalizeURL(u); got != tc.want {
+			t.Errorf("normalizeURL(%q) = %q, want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestAgo(t *testing.T) {
+	cases := []struct {
+		d    time.Duration
+		want string
+	}{
+		{10 * time.Second, "just now"},
+		{time.Minute, "1 minute ago"},
+		{45 * time.Minute, "45 minutes ago"},
+		{90 * time.Minute, "1 hour ago"},
+		{23 * time.Hour, "23 hours ago"},
+		{25 * time.Hour, "1 day ago"},
+		{3*24*time.Hour + time.Hour, "3 days ago"},
+	}
+	for _, tc := range cases {
+		if got := ago(tc.d); got != tc.want {
+			t.Errorf("ago(%v) = %q, want %q", tc.d, got, tc.want)
+		}
+	}
+}
+
+func TestProcessUrls_RepostReusesShortLink(t *testing.T) {
+	srv := newYirpServer(t)
+	defer srv.Close()
+
+	app := newTestApp()
+	app.config.yirpAPIAddr = srv.URL
+	app.config.urlDupWindow = 24 * time.Hour
+
+	app.urls.add(urlRecord{
+		Poster: "#1234", Name: "Dino", Posted: time.Now().UTC().Add(-3 * time.Hour),
+		Short: "https://y.rp/old", Long: "https://example.com/a", Norm: "https://example.com/a",
+	})
+
+	got, err := app.processUrls("#99", "Rex", "#20", []string{"http://www.example.com/a/?utm_source=feed"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != "pose > https://y.rp/old (first posted by Dino 3 hours ago)\n" {
+		t.Errorf("unexpected repost announcement: %q", got)
+	}
+	recs, _ := app.urls.find(nil)
+	if len(recs) != 2 || recs[0].Name != "Rex" || recs[0].Short != "https://y.rp/old" {
+		t.Errorf("repost not recorded against the old short link: %+v", recs)
+	}
+}
+
+func TestProcessUrls_RepostOutsideWindowIsShortened(t *testing.T) {
+	srv := newYirpServer(t)
+	defer srv.Close()
+
+	app := newTestApp()
+	app.config.yirpAPIAddr = srv.URL
+	app.config.urlDupWindow = 24 * time.Hour
+
+	app.urls.add(urlRecord{
+		Poster: "#1234", Name: "Dino", Posted: time.Now().UTC().Add(-48 * time.Hour),
+		Short: "https://y.rp/old", Long: "https://example.com/a", Norm: "https://example.com/a",
+	})
+
+	got, _ := app.processUrls("#99", "Rex", "#20", []string{"https://example.com/a"})
+	if !strings.HasPrefix(got, "add_url #99 https://y.rp/s1 ") {
+		t.Errorf("expected a fresh short link, got: %q", got)
+	}
+}
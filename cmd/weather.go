@@ -0,0 +1,336 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WeatherProvider is a source of current conditions, daily forecasts, past
+// days, air quality and sun and moon times. Providers translate their own
+// responses into the models below, which are all the formatters see.
+type WeatherProvider interface {
+	Name() string
+	Current(loc string) (weatherReport, error)
+	Forecast(loc string, days int) (weatherForecast, error)
+	History(loc string, date time.Time) (weatherForecast, error)
+	AirQuality(loc string) (airQuality, error)
+	Astronomy(loc string) (astronomy, error)
+}
+
+// weatherPlace is where a report is for. Region is the state or province
+// and TZ the IANA time zone, when the provider knows it.
+type weatherPlace struct {
+	Name    string
+	Region  string
+	Country string
+	TZ      string
+}
+
+// String is "City, State" in the US and "City, Country" elsewhere.
+func (p weatherPlace) String() string {
+	if isUSA(p.Country) {
+		return p.Name + ", " + p.Region
+	}
+	if p.Country == "" {
+		return p.Name
+	}
+	return p.Name + ", " + p.Country
+}
+
+// weatherReport holds current conditions in both unit systems, so formatting
+// never has to convert.
+type weatherReport struct {
+	Place     weatherPlace
+	Condition string
+	TempC     float64
+	TempF     float64
+	FeelsC    float64
+	FeelsF    float64
+	Humidity  float64
+	WindKph   float64
+	WindMph   float64
+	GustKph   float64
+	GustMph   float64
+	WindDir   string
+	PrecipMm  float64
+	PrecipIn  float64
+	UV        float64
+}
+
+type forecastDay struct {
+	Date       time.Time
+	Condition  string
+	MaxC       float64
+	MaxF       float64
+	MinC       float64
+	MinF       float64
+	PrecipMm   float64
+	PrecipIn   float64
+	RainChance int
+	SnowChance int
+}
+
+type weatherForecast struct {
+	Place weatherPlace
+	Days  []forecastDay
+}
+
+// airQuality is the air at a place now. Category is the US EPA index, 1
+// (Good) to 6 (Hazardous), or 0 when the provider has no data there.
+// Pollutants are in µg/m³.
+type airQuality struct {
+	Place    weatherPlace
+	Category int
+	PM2_5    float64
+	PM10     float64
+	O3       float64
+	NO2      float64
+}
+
+// astronomy is today's sun and moon at a place. Times are local to the
+// place, as "03:04 PM".
+type astronomy struct {
+	Place            weatherPlace
+	Sunrise          string
+	Sunset           string
+	MoonPhase        string
+	MoonIllumination int
+}
+
+// errLocationNotFound means the provider understood the request but has no
+// such place. It stops failover, since the next provider won't know it either.
+var errLocationNotFound = errors.New("location not found")
+
+// weatherStatusError is an unexpected HTTP status from a provider, such as a
+// bad key or an exhausted quota.
+type weatherStatusError struct {
+	provider string
+	code     int
+}
+
+func (e *weatherStatusError) Error() string {
+	return e.provider + " returned code " + strconv.Itoa(e.code)
+}
+
+// weatherClient is shared by the weather providers. Its timeout makes a
+// provider that hangs fail over to the next instead of holding up the bot.
+var weatherClient = &http.Client{Timeout: 10 * time.Second}
+
+// weatherChain tries providers in order until one answers.
+type weatherChain []WeatherProvider
+
+// tryProviders asks each provider in turn through ask and returns the first
+// answer. Not found is an answer too, since the next provider won't know the
+// place either.
+func tryProviders[T any](c weatherChain, ask func(p WeatherProvider) (T, error)) (T, error) {
+	var zero T
+	var errs []error
+	for _, p := range c {
+		v, err := ask(p)
+		if err == nil || errors.Is(err, errLocationNotFound) {
+			return v, err
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+	}
+	if len(errs) == 0 {
+		return zero, errors.New("no weather providers configured")
+	}
+	return zero, errors.Join(errs...)
+}
+
+func (c weatherChain) current(loc string) (weatherReport, error) {
+	return tryProviders(c, func(p WeatherProvider) (weatherReport, error) { return p.Current(loc) })
+}
+
+func (c weatherChain) forecast(loc string, days int) (weatherForecast, error) {
+	return tryProviders(c, func(p WeatherProvider) (weatherForecast, error) { return p.Forecast(loc, days) })
+}
+
+func (c weatherChain) history(loc string, date time.Time) (weatherForecast, error) {
+	return tryProviders(c, func(p WeatherProvider) (weatherForecast, error) { return p.History(loc, date) })
+}
+
+func (c weatherChain) airQuality(loc string) (airQuality, error) {
+	return tryProviders(c, func(p WeatherProvider) (airQuality, error) { return p.AirQuality(loc) })
+}
+
+func (c weatherChain) astronomy(loc string) (astronomy, error) {
+	return tryProviders(c, func(p WeatherProvider) (astronomy, error) { return p.Astronomy(loc) })
+}
+
+// currentWeather returns current conditions for loc through the cache.
+func (app *application) currentWeather(loc string) (weatherReport, error) {
+	return cached(app.cache, "weather", "current|"+strings.ToLower(loc), func() (weatherReport, bool, error) {
+		r, err := app.weather.current(loc)
+		return r, true, err
+	})
+}
+
+// buildWeatherProviders assembles the chain named by config.weatherProviders.
+// weatherapi is left out when there is no key for it.
+func (app *application) buildWeatherProviders() (weatherChain, error) {
+	var chain weatherChain
+	for _, name := range strings.Split(app.config.weatherProviders, ",") {
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "":
+		case "weatherapi":
+			if app.config.weatherapikey == "" {
+				app.infoLog.Print("WEATHER_APIKEY is not set, skipping weatherapi")
+				continue
+			}
+			chain = append(chain, &weatherAPIProvider{app: app})
+		case "openmeteo":
+			chain = append(chain, &openMeteoProvider{app: app})
+		default:
+			return nil, fmt.Errorf("unknown weather provider %q", name)
+		}
+	}
+	return chain, nil
+}
+
+// weatherFailure turns a failed lookup into the reply players see, or
+// returns the error when there is nothing useful to tell them.
+func (app *application) weatherFailure(kind, loc string, err error) (string, error) {
+	if errors.Is(err, errLocationNotFound) {
+		return kind + " error: " + mushEscape(loc) + " not found. Try using a city state or city country pair.\n", nil
+	}
+	app.errorLog.Printf("%s request failed: %s", strings.ToLower(kind), err)
+	var se *weatherStatusError
+	if errors.As(err, &se) {
+		return kind + " error: API returned code: " + strconv.Itoa(se.code) + "\n", nil
+	}
+	return "", err
+}
+
+// formatCurrent renders current conditions on one line.
+func formatCurrent(r weatherReport, units string, verbose bool) string {
+	units = resolveUnits(units, r.Place.Country)
+	result := fmt.Sprintf("%v: %v %s %.1f%%%% %s %v", r.Place, r.Condition,
+		formatTemp(r.TempC, r.TempF, units), r.Humidity, formatSpeed(r.WindKph, r.WindMph, units), r.WindDir)
+	if verbose {
+		result += fmt.Sprintf(", feels like %s, gusts %s, precip %s, UV %.0f",
+			formatTemp(r.FeelsC, r.FeelsF, units), formatSpeed(r.GustKph, r.GustMph, units),
+			formatPrecip(r.PrecipMm, r.PrecipIn, units), r.UV)
+	}
+	return result + "\n"
+}
+
+// formatForecast renders a forecast as a heading and one line per day,
+// joined with %r.
+func formatForecast(f weatherForecast, units string) string {
+	units = resolveUnits(units, f.Place.Country)
+	lines := []string{f.Place.String() + ":"}
+	for _, d := range f.Days {
+		precip, kind := d.RainChance, "rain"
+		if d.SnowChance > precip {
+			precip, kind = d.SnowChance, "snow"
+		}
+		line := fmt.Sprintf("%s: %s %s %d%%%% %s", d.Date.Format("Mon Jan 2"), d.Condition,
+			formatTempRange(d.MaxC, d.MinC, d.MaxF, d.MinF, units), precip, kind)
+		if d.PrecipMm > 0 {
+			line += " " + formatPrecip(d.PrecipMm, d.PrecipIn, units)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "%r") + "\n"
+}
+
+// weatherAPIProvider is weatherapi.com, which needs WEATHER_APIKEY.
+type weatherAPIProvider struct {
+	app *application
+}
+
+func (p *weatherAPIProvider) Name() string { return "weatherapi" }
+
+// get fetches an endpoint into v, mapping failed statuses to errors.
+func (p *weatherAPIProvider) get(endpoint string, params url.Values, v any) error {
+	status, err := p.app.weatherAPIGet(endpoint, params, v)
+	switch {
+	case err != nil:
+		return err
+	case status == 400:
+		return errLocationNotFound
+	case status > 299:
+		return &weatherStatusError{provider: p.Name(), code: status}
+	}
+	return nil
+}
+
+func (p *weatherAPIProvider) Current(loc string) (weatherReport, error) {
+	var wr WeatherAPIResponse
+	if err := p.get("current.json", url.Values{"q": {loc}, "aqi": {"no"}}, &wr); err != nil {
+		return weatherReport{}, err
+	}
+	c := wr.Current
+	return weatherReport{
+		Place:     weatherPlace{Name: wr.Location.Name, Region: wr.Location.Region, Country: wr.Location.Country, TZ: wr.Location.Tz_id},
+		Condition: c.Condition.Text,
+		TempC:     c.Temp_c,
+		TempF:     c.Temp_f,
+		FeelsC:    c.Feelslike_c,
+		FeelsF:    c.Feelslike_f,
+		Humidity:  c.Humidity,
+		WindKph:   c.Wind_kph,
+		WindMph:   c.Wind_mph,
+		GustKph:   c.Gust_kph,
+		GustMph:   c.Gust_mph,
+		WindDir:   c.Wind_dir,
+		PrecipMm:  c.Precip_mm,
+		PrecipIn:  c.Precip_in,
+		UV:        c.Uv,
+	}, nil
+}
+
+func (p *weatherAPIProvider) Forecast(loc string, days int) (weatherForecast, error) {
+	params := url.Values{"q": {loc}, "days": {strconv.Itoa(days)}, "aqi": {"no"}, "alerts": {"no"}}
+	var fr WeatherAPIForecastResponse
+	if err := p.get("forecast.json", params, &fr); err != nil {
+		return weatherForecast{}, err
+	}
+	return fr.forecast(), nil
+}
+
+// weatherAPIHistoryDays is how far back weatherapi's history endpoint goes on
+// the free plan. Older days are left to the next provider.
+const weatherAPIHistoryDays = 7
+
+func (p *weatherAPIProvider) History(loc string, date time.Time) (weatherForecast, error) {
+	if time.Since(date) > weatherAPIHistoryDays*24*time.Hour {
+		return weatherForecast{}, fmt.Errorf("history only goes back %d days", weatherAPIHistoryDays)
+	}
+	var fr WeatherAPIForecastResponse
+	if err := p.get("history.json", url.Values{"q": {loc}, "dt": {date.Format("2006-01-02")}}, &fr); err != nil {
+		return weatherForecast{}, err
+	}
+	return fr.forecast(), nil
+}
+
+// forecast converts a forecast or history response, which share a shape.
+func (fr WeatherAPIForecastResponse) forecast() weatherForecast {
+	f := weatherForecast{Place: weatherPlace{Name: fr.Location.Name, Region: fr.Location.Region, Country: fr.Location.Country}}
+	for _, fd := range fr.Forecast.Forecastday {
+		date, err := time.Parse("2006-01-02", fd.Date)
+		if err != nil {
+			continue
+		}
+		d := fd.Day
+		f.Days = append(f.Days, forecastDay{
+			Date:       date,
+			Condition:  d.Condition.Text,
+			MaxC:       d.Maxtemp_c,
+			MaxF:       d.Maxtemp_f,
+			MinC:       d.Mintemp_c,
+			MinF:       d.Mintemp_f,
+			PrecipMm:   d.Totalprecip_mm,
+			PrecipIn:   d.Totalprecip_in,
+			RainChance: d.Daily_chance_of_rain,
+			SnowChance: d.Daily_chance_of_snow,
+		})
+	}
+	return f
+}
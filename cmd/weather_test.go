@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	geocodeFixture = `{"results": [
+  {"name": "Portland", "latitude": 45.52, "longitude": -122.68, "country": "United States", "country_code": "US", "admin1": "Oregon"},
+  {"name": "Portland", "latitude": 43.66, "longitude": -70.26, "country": "United States", "country_code": "US", "admin1": "Maine"}
+]}`
+	openMeteoFixture = `{
+  "current": {"temperature_2m": 20, "relative_humidity_2m": 55, "apparent_temperature": 19, "precipitation": 2.54,
+    "weather_code": 2, "wind_speed_10m": 16.09344, "wind_direction_10m": 225, "wind_gusts_10m": 32.18688, "uv_index": 4},
+  "daily": {"time": ["2026-06-03", "2026-06-04"], "weather_code": [61, 73], "temperature_2m_max": [20, -1],
+    "temperature_2m_min": [10, -6], "precipitation_sum": [0, 5], "precipitation_probability_max": [40, 70]}
+}`
+)
+
+// newOpenMeteoServer stands in for both the geocoding and forecast APIs,
+// recording each request's path and query.
+func newOpenMeteoServer(t *testing.T, seen *[]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/search":
+			*seen = append(*seen, "search "+q.Get("name"))
+			if q.Get("name") == "Portland" {
+				fmt.Fprint(w, geocodeFixture)
+				return
+			}
+			fmt.Fprint(w, `{}`)
+		case "/forecast":
+			*seen = append(*seen, "forecast "+q.Get("latitude")+","+q.Get("longitude"))
+			fmt.Fprint(w, openMeteoFixture)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func newOpenMeteoApp(t *testing.T, seen *[]string) (*application, func()) {
+	srv := newOpenMeteoServer(t, seen)
+	app := newTestApp()
+	app.config.openMeteoBaseURL = srv.URL
+	app.config.openMeteoGeoURL = srv.URL
+	app.weather = weatherChain{&openMeteoProvider{app: app}}
+	return app, srv.Close
+}
+
+func TestOpenMeteo_Current(t *testing.T) {
+	var seen []string
+	app, done := newOpenMeteoApp(t, &seen)
+	defer done()
+
+	got, err := app.sendWeatherRequest("Portland", "", true)
+	want := "Portland, Oregon: Partly cloudy 68.0F 55.0%% 10.0mph SW, feels like 66.2F, gusts 20.0mph, precip 0.10in, UV 4\n"
+	if err != nil || got != want {
+		t.Errorf("got  %q, %v\nwant %q", got, err, want)
+	}
+	if strings.Join(seen, "|") != "search Portland|forecast 45.52,-122.68" {
+		t.Errorf("requests %q", seen)
+	}
+}
+
+func TestOpenMeteo_CityStateAndCoordinates(t *testing.T) {
+	var seen []string
+	app, done := newOpenMeteoApp(t, &seen)
+	defer done()
+
+	got, _ := app.sendWeatherRequest("Portland Maine", unitsMetric, false)
+	if !strings.HasPrefix(got, "Portland, Maine: Partly cloudy 20.0C") {
+		t.Errorf("city state: %q", got)
+	}
+	got, _ = app.sendWeatherRequest("Portland Narnia", unitsMetric, false)
+	if got != "Weather error: Portland Narnia not found. Try using a city state or city country pair.\n" {
+		t.Errorf("unknown qualifier: %q", got)
+	}
+	seen = nil
+	got, _ = app.sendWeatherRequest("51.5,-0.12", unitsMetric, false)
+	if !strings.HasPrefix(got, "51.5,-0.12: Partly cloudy 20.0C") || strings.Join(seen, "|") != "forecast 51.5,-0.12" {
+		t.Errorf("coordinates: %q %q", got, seen)
+	}
+}
+
+func TestOpenMeteo_Forecast(t *testing.T) {
+	var seen []string
+	app, done := newOpenMeteoApp(t, &seen)
+	defer done()
+
+	got, _ := app.sendForecastRequest("Portland", unitsMetric, 2)
+	want := "Portland, Oregon:%rWed Jun 3: Light rain 20/10C 40%% rain%rThu Jun 4: Moderate snow -1/-6C 70%% snow 5.0mm\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWeatherChain_FailsOverOnQuota(t *testing.T) {
+	var quota atomic.Int32
+	weatherapi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("q") == "nowhere" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		quota.Add(1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer weatherapi.Close()
+	var seen []string
+	app, done := newOpenMeteoApp(t, &seen)
+	defer done()
+	app.config.weatherBaseURL = weatherapi.URL
+	app.weather = weatherChain{&weatherAPIProvider{app: app}, &openMeteoProvider{app: app}}
+
+	got, _ := app.sendWeatherRequest("Portland", "", false)
+	if !strings.HasPrefix(got, "Portland, Oregon: Partly cloudy") || quota.Load() != 1 {
+		t.Errorf("no failover: %q", got)
+	}
+
+	// Not found is an answer, not a failure.
+	seen = nil
+	got, _ = app.sendWeatherRequest("nowhere", "", false)
+	if !strings.Contains(got, "nowhere not found") || len(seen) != 0 {
+		t.Errorf("not found failed over: %q %q", got, seen)
+	}
+
+	app.weather = weatherChain{&weatherAPIProvider{app: app}}
+	if got, _ := app.sendForecastRequest("Portland", "", 3); got != "Forecast error: API returned code: 403\n" {
+		t.Errorf("last provider's status not reported: %q", got)
+	}
+}
+
+func TestWeatherChain_FailsOverOnTimeout(t *testing.T) {
+	release := make(chan struct{})
+	weatherapi := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer weatherapi.Close()
+	defer close(release)
+	var seen []string
+	app, done := newOpenMeteoApp(t, &seen)
+	defer done()
+	app.config.weatherBaseURL = weatherapi.URL
+	app.weather = weatherChain{&weatherAPIProvider{app: app}, &openMeteoProvider{app: app}}
+
+	timeout := weatherClient.Timeout
+	weatherClient.Timeout = 50 * time.Millisecond
+	defer func() { weatherClient.Timeout = timeout }()
+
+	if got, _ := app.sendWeatherRequest("Portland", "", false); !strings.HasPrefix(got, "Portland, Oregon: Partly cloudy") {
+		t.Errorf("no failover: %q", got)
+	}
+}
+
+func TestBuildWeatherProviders(t *testing.T) {
+	app := newTestApp()
+	app.config.weatherProviders = "openmeteo, weatherapi"
+	chain, err := app.buildWeatherProviders()
+	if err != nil || len(chain) != 1 || chain[0].Name() != "openmeteo" {
+		t.Errorf("weatherapi without a key: %v %v", chain, err)
+	}
+	app.config.weatherapikey = "k"
+	chain, _ = app.buildWeatherProviders()
+	if len(chain) != 2 || chain[1].Name() != "weatherapi" {
+		t.Errorf("order not kept: %v", chain)
+	}
+	app.config.weatherProviders = "darksky"
+	if _, err := app.buildWeatherProviders(); err == nil {
+		t.Error("unknown provider accepted")
+	}
+}
+
+func TestCompassPoint(t *testing.T) {
+	for deg, want := range map[float64]string{0: "N", 11: "N", 12: "NNE", 225: "SW", 350: "N", 359.9: "N", 270: "W"} {
+		if got := compassPoint(deg); got != want {
+			t.Errorf("compassPoint(%v) = %q, want %q", deg, got, want)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// WeatherAPIAirQuality is the air_quality block weatherapi.com adds to
+// current conditions when asked with aqi=yes. Pollutants are in µg/m³.
+type WeatherAPIAirQuality struct {
+	CO         float64 `json:"co"`
+	NO2        float64 `json:"no2"`
+	O3         float64 `json:"o3"`
+	SO2        float64 `json:"so2"`
+	PM2_5      float64 `json:"pm2_5"`
+	PM10       float64 `json:"pm10"`
+	USEPAIndex int     `json:"us-epa-index"`
+	GBDefra    int     `json:"gb-defra-index"`
+}
+
+type WeatherAPIAQIResponse struct {
+	Location struct {
+		Name    string `json:"name"`
+		Region  string `json:"region"`
+		Country string `json:"country"`
+	} `json:"location"`
+
+	Current struct {
+		AirQuality *WeatherAPIAirQuality `json:"air_quality"`
+	} `json:"current"`
+}
+
+type WeatherAPIAstronomyResponse struct {
+	Location struct {
+		Name    string `json:"name"`
+		Region  string `json:"region"`
+		Country string `json:"country"`
+	} `json:"location"`
+
+	Astronomy struct {
+		Astro struct {
+			Sunrise          string  `json:"sunrise"`
+			Sunset           string  `json:"sunset"`
+			Moonrise         string  `json:"moonrise"`
+			Moonset          string  `json:"moonset"`
+			MoonPhase        string  `json:"moon_phase"`
+			MoonIllumination flexInt `json:"moon_illumination"`
+		} `json:"astro"`
+	} `json:"astronomy"`
+}
+
+// flexInt decodes a number the API has sent both bare and quoted.
+type flexInt int
+
+func (n *flexInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		*n = 0
+		return nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	*n = flexInt(f)
+	return nil
+}
+
+// epaCategories names the US EPA index values 1..6.
+var epaCategories = []string{"", "Good", "Moderate", "Unhealthy for sensitive groups", "Unhealthy", "Very unhealthy", "Hazardous"}
+
+func epaCategory(index int) string {
+	if index < 1 || index >= len(epaCategories) {
+		return "Unknown"
+	}
+	return epaCategories[index]
+}
+
+// stripFlag removes any of names from args and reports whether one was there.
+func stripFlag(args string, names ...string) (string, bool) {
+	var rest []string
+	found := false
+	for _, f := range strings.Fields(args) {
+		matched := false
+		for _, n := range names {
+			if strings.EqualFold(f, n) {
+				matched = true
+			}
+		}
+		if matched {
+			found = true
+			continue
+		}
+		rest = append(rest, f)
+	}
+	return strings.Join(rest, " "), found
+}
+
+// weatherAPIGet fetches a weatherapi.com endpoint into v. A 400 is returned
+// as a status with no error, since it means the location was not found.
+func (app *application) weatherAPIGet(endpoint string, params url.Values, v any) (int, error) {
+	params.Set("key", app.config.weatherapikey)
+	res, err := weatherClient.Get(app.config.weatherBaseURL + "/" + endpoint + "?" + params.Encode())
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode > 299 {
+		return res.StatusCode, nil
+	}
+	return res.StatusCode, json.NewDecoder(res.Body).Decode(v)
+}
+
+func (p *weatherAPIProvider) AirQuality(loc string) (airQuality, error) {
+	var ar WeatherAPIAQIResponse
+	if err := p.get("current.json", url.Values{"q": {loc}, "aqi": {"yes"}}, &ar); err != nil {
+		return airQuality{}, err
+	}
+	a := airQuality{Place: weatherPlace{Name: ar.Location.Name, Region: ar.Location.Region, Country: ar.Location.Country}}
+	if aq := ar.Current.AirQuality; aq != nil {
+		a.Category, a.PM2_5, a.PM10, a.O3, a.NO2 = aq.USEPAIndex, aq.PM2_5, aq.PM10, aq.O3, aq.NO2
+	}
+	return a, nil
+}
+
+func (p *weatherAPIProvider) Astronomy(loc string) (astronomy, error) {
+	var ar WeatherAPIAstronomyResponse
+	if err := p.get("astronomy.json", url.Values{"q": {loc}}, &ar); err != nil {
+		return astronomy{}, err
+	}
+	a := ar.Astronomy.Astro
+	return astronomy{
+		Place:            weatherPlace{Name: ar.Location.Name, Region: ar.Location.Region, Country: ar.Location.Country},
+		Sunrise:          a.Sunrise,
+		Sunset:           a.Sunset,
+		MoonPhase:        a.MoonPhase,
+		MoonIllumination: int(a.MoonIllumination),
+	}, nil
+}
+
+// sendAQIRequest reports the air quality at loc.
+func (app *application) sendAQIRequest(loc string) (string, error) {
+	aq, err := cached(app.cache, "weather", "aqi|"+strings.ToLower(loc), func() (airQuality, bool, error) {
+		aq, err := app.weather.airQuality(loc)
+		return aq, true, err
+	})
+	if err != nil {
+		return app.weatherFailure("AQI", loc, err)
+	}
+	if aq.Category == 0 {
+		return aq.Place.String() + ": no air quality data.\n", nil
+	}
+	return fmt.Sprintf("%s: AQI %d (%s) PM2.5 %.1f PM10 %.1f O3 %.1f NO2 %.1f\n", aq.Place,
+		aq.Category, epaCategory(aq.Category), aq.PM2_5, aq.PM10, aq.O3, aq.NO2), nil
+}
+
+// sendSunRequest reports today's sunrise, sunset and moon phase at loc.
+func (app *application) sendSunRequest(loc string) (string, error) {
+	a, err := cached(app.cache, "weather", "sun|"+strings.ToLower(loc), func() (astronomy, bool, error) {
+		a, err := app.weather.astronomy(loc)
+		return a, true, err
+	})
+	if err != nil {
+		return app.weatherFailure("Sun", loc, err)
+	}
+	return fmt.Sprintf("%s: sunrise %s, sunset %s, moon %s (%d%%%% lit)\n",
+		a.Place, a.Sunrise, a.Sunset, a.MoonPhase, a.MoonIllumination), nil
+}
+
+// placesFor returns the places a lookup covers: up to five comma-separated
+// locations given with the command, or else the player's saved location.
+// A saved location is one place even with a comma in it ("Denver, CO").
+// It is empty when there is neither.
+func (app *application) placesFor(userID, where string) []string {
+	if strings.TrimSpace(where) == "" {
+		if saved := strings.TrimSpace(app.prefs.get(userID).Location); saved != "" {
+			return []string{saved}
+		}
+		return nil
+	}
+	var places []string
+	for _, loc := range strings.Split(where, ",") {
+		if loc = strings.TrimSpace(loc); loc != "" && len(places) < 5 {
+			places = append(places, loc)
+		}
+	}
+	return places
+}
+
+// forLocations runs lookup for each place placesFor finds in where, posing
+// each reply with prefix.
+func (app *application) forLocations(userID, where, prefix, kind string, lookup func(loc string) (string, error)) string {
+	locations := app.placesFor(userID, where)
+	if len(locations) == 0 {
+		return "@pemit " + userID + "=Gravybot: no location given. Try: gravybot set location <place>\n"
+	}
+	var commands []string
+	for _, loc := range locations {
+		response, err := lookup(parseLatLon(app.resolveLocation(loc)))
+		if err != nil {
+			response = "Error: " + kind + " api call failed.\n"
+		}
+		commands = append(commands, "pose "+prefix+"> "+response)
+	}
+	return strings.Join(commands, "")
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	detailCurrentFixture = `{
+  "location": {"name": "Denver", "region": "Colorado", "country": "United States of America"},
+  "current": {"temp_c": 21, "temp_f": 69.8, "humidity": 20, "wind_kph": 16.2, "wind_mph": 10.1, "wind_dir": "W",
+    "feelslike_c": 20.4, "feelslike_f": 68.7, "gust_kph": 30.6, "gust_mph": 19, "precip_mm": 0.3, "precip_in": 0.01,
+    "uv": 7, "condition": {"text": "Sunny"},
+    "air_quality": {"co": 230.3, "no2": 13.5, "o3": 88.4, "so2": 1.2, "pm2_5": 12.3, "pm10": 20.1,
+      "us-epa-index": 2, "gb-defra-index": 2}}
+}`
+	astronomyFixture = `{
+  "location": {"name": "London", "region": "City of London, Greater London", "country": "United Kingdom"},
+  "astronomy": {"astro": {"sunrise": "04:43 AM", "sunset": "09:18 PM", "moonrise": "10:02 PM",
+    "moonset": "05:30 AM", "moon_phase": "Waxing Gibbous", "moon_illumination": %s}}
+}`
+)
+
+// newDetailServer serves the current and astronomy fixtures, recording the
+// aqi parameter of each current.json call.
+func newDetailServer(t *testing.T, aqi *[]string) *httptest.Server {
+	t.Helper()
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("q") == "nowhere" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		switch r.URL.Path {
+		case "/current.json":
+			*aqi = append(*aqi, q.Get("aqi"))
+			w.Write([]byte(detailCurrentFixture))
+		case "/astronomy.json":
+			illum := "75"
+			if q.Get("q") == "quoted" {
+				illum = `"75"`
+			}
+			w.Write([]byte(strings.Replace(astronomyFixture, "%s", illum, 1)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestCheckLine_WeatherVerbose(t *testing.T) {
+	var aqi []string
+	srv := newDetailServer(t, &aqi)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather -v Denver"`)
+	want := "pose W> Denver, Colorado: Sunny 69.8F 20.0%% 10.1mph W, feels like 68.7F, gusts 19.0mph, precip 0.01in, UV 7\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	got, _ = app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather -c --verbose Denver"`)
+	if !strings.HasSuffix(got, "W, feels like 20.4C, gusts 30.6kph, precip 0.3mm, UV 7\n") {
+		t.Errorf("metric verbose: %q", got)
+	}
+	got, _ = app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot weather Denver"`)
+	if strings.Contains(got, "feels like") {
+		t.Errorf("plain weather shows detail: %q", got)
+	}
+}
+
+func TestCheckLine_AQI(t *testing.T) {
+	var aqi []string
+	srv := newDetailServer(t, &aqi)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot aqi Denver, nowhere"`)
+	want := "pose A> Denver, Colorado: AQI 2 (Moderate) PM2.5 12.3 PM10 20.1 O3 88.4 NO2 13.5\n" +
+		"pose A> AQI error: nowhere not found. Try using a city state or city country pair.\n"
+	if got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if len(aqi) != 1 || aqi[0] != "yes" {
+		t.Errorf("aqi parameter %q", aqi)
+	}
+}
+
+func TestCheckLine_Sun(t *testing.T) {
+	var aqi []string
+	srv := newDetailServer(t, &aqi)
+	defer srv.Close()
+	app := newTestApp()
+	app.config.weatherBaseURL = srv.URL
+
+	if got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun"`); !strings.Contains(got, "no location given") {
+		t.Errorf("no location: %q", got)
+	}
+	app.prefs.set("#99", "location", "London")
+	want := "pose S> London, United Kingdom: sunrise 04:43 AM, sunset 09:18 PM, moon Waxing Gibbous (75%% lit)\n"
+	if got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun"`); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	if got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun quoted"`); !strings.Contains(got, "(75%% lit)") {
+		t.Errorf("quoted illumination: %q", got)
+	}
+}
+
+func TestEPACategory(t *testing.T) {
+	for i, want := range []string{"Unknown", "Good", "Moderate", "Unhealthy for sensitive groups", "Unhealthy", "Very unhealthy", "Hazardous", "Unknown"} {
+		if got := epaCategory(i); got != want {
+			t.Errorf("epaCategory(%d) = %q, want %q", i, got, want)
+		}
+	}
+	var n flexInt
+	if err := json.Unmarshal([]byte(`null`), &n); err != nil || n != 0 {
+		t.Errorf("null: %v %v", n, err)
+	}
+}
+
+func TestOpenMeteo_AirQualityAndSun(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/search":
+			w.Write([]byte(geocodeFixture))
+		case "/air-quality":
+			w.Write([]byte(`{"current": {"us_aqi": 72, "pm2_5": 12.3, "pm10": 20.1, "ozone": 88.4, "nitrogen_dioxide": 13.5}}`))
+		case "/forecast":
+			w.Write([]byte(`{"timezone": "America/Los_Angeles",
+  "daily": {"time": ["2026-10-18"], "sunrise": ["2026-10-18T07:26"], "sunset": ["2026-10-18T18:19"]}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	app := newTestApp()
+	app.config.openMeteoBaseURL = srv.URL
+	app.config.openMeteoGeoURL = srv.URL
+	app.config.openMeteoAirURL = srv.URL
+	app.weather = weatherChain{&openMeteoProvider{app: app}}
+
+	got, _ := app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot aqi Portland"`)
+	if want := "pose A> Portland, Oregon: AQI 2 (Moderate) PM2.5 12.3 PM10 20.1 O3 88.4 NO2 13.5\n"; got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	got, _ = app.checkLineForRegexps(`[Rex(#99)] Rex says "gravybot sun Portland"`)
+	if !strings.HasPrefix(got, "pose S> Portland, Oregon: sunrise 07:26 AM, sunset 06:19 PM, moon ") {
+		t.Errorf("sun: %q", got)
+	}
+}
+
+func TestMoonPhase(t *testing.T) {
+	tests := []struct {
+		date  time.Time
+		phase string
+		lit   int
+	}{
+		{time.Date(2024, 4, 8, 18, 0, 0, 0, time.UTC), "New Moon", 0},
+		{time.Date(2024, 4, 23, 23, 0, 0, 0, time.UTC), "Full Moon", 100},
+		{time.Date(2024, 4, 15, 19, 0, 0, 0, time.UTC), "First Quarter", 50},
+	}
+	for _, tt := range tests {
+		phase, lit := moonPhase(tt.date)
+		if phase != tt.phase || lit < tt.lit-8 || lit > tt.lit+8 {
+			t.Errorf("moonPhase(%s) = %s %d%%, want %s about %d%%", tt.date.Format("Jan 2 2006"), phase, lit, tt.phase, tt.lit)
+		}
+	}
+}